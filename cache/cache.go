@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"cpe/calendar/logger"
+	"cpe/calendar/types"
+	"cpe/calendar/window"
+)
+
+// FetchFunc loads a timetable from upstream
+type FetchFunc func(ctx context.Context) ([]types.Event, error)
+
+// Entry is a cached timetable
+type Entry struct {
+	Events []types.Event
+	// FetchedAt is when the timetable was last fetched from upstream
+	FetchedAt time.Time
+	// ModifiedAt is when the fetched timetable last differed from the previous fetch
+	ModifiedAt time.Time
+}
+
+// Cache keeps the last good timetable of each user and date window in memory.
+// Entries younger than the TTL are served as is; older ones are served immediately
+// while a background refresh runs, until they reach the maximum staleness.
+// Concurrent fetches for the same key are coalesced into a single upstream call.
+type Cache struct {
+	ttl       time.Duration
+	maxStale  time.Duration
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	Entry
+	hash string
+	// inflight is the fetch currently running for this key, if any
+	inflight *call
+}
+
+type call struct {
+	done  chan struct{}
+	entry Entry
+	err   error
+}
+
+// New creates a cache refreshing entries after ttl and dropping them after maxStale
+func New(ttl, maxStale time.Duration) *Cache {
+	if maxStale < ttl {
+		maxStale = ttl
+	}
+	return &Cache{
+		ttl:      ttl,
+		maxStale: maxStale,
+		entries:  map[string]*entry{},
+	}
+}
+
+// Key derives the cache key of a user and date window without keeping the credentials around
+func Key(username, password string, w window.Window) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + password + "\x00" + w.StartDate() + "\x00" + w.EndDate()))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the timetable for key, calling fetch when it is missing or stale.
+// The fetch is shared with other callers so it is not canceled with ctx, but Get
+// stops waiting for it and returns ctx's error once ctx is done.
+func (c *Cache) Get(ctx context.Context, key string, fetch FetchFunc) (Entry, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	c.sweep(now)
+
+	e, ok := c.entries[key]
+	if !ok {
+		e = &entry{}
+		c.entries[key] = e
+	}
+
+	if !e.FetchedAt.IsZero() {
+		age := now.Sub(e.FetchedAt)
+
+		if age < c.ttl {
+			cached := e.Entry
+			c.mu.Unlock()
+			logger.Ctx(ctx).Debug().
+				Dur("age", age).
+				Msg("Serving timetable from cache")
+			return cached, nil
+		}
+
+		if age < c.maxStale {
+			// Serve stale data right away and refresh in the background
+			cached := e.Entry
+			if e.inflight == nil {
+				c.start(ctx, key, e, fetch)
+			}
+			c.mu.Unlock()
+			logger.Ctx(ctx).Info().
+				Dur("age", age).
+				Msg("Serving stale timetable while refreshing")
+			return cached, nil
+		}
+	}
+
+	// Nothing usable: wait for the shared upstream call
+	inflight := e.inflight
+	if inflight == nil {
+		inflight = c.start(ctx, key, e, fetch)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-inflight.done:
+		return inflight.entry, inflight.err
+	case <-ctx.Done():
+		return Entry{}, ctx.Err()
+	}
+}
+
+// start launches fetch for an entry, the caller must hold the lock
+func (c *Cache) start(ctx context.Context, key string, e *entry, fetch FetchFunc) *call {
+	inflight := &call{done: make(chan struct{})}
+	e.inflight = inflight
+
+	// Keep the request values (request ID...) but not its cancellation
+	fetchCtx := context.WithoutCancel(ctx)
+
+	go func() {
+		events, err := fetch(fetchCtx)
+		fetchedAt := time.Now()
+
+		c.mu.Lock()
+		e.inflight = nil
+		if err == nil {
+			// Only move the modification time when the timetable actually changed
+			hash := hashEvents(events)
+			if hash != e.hash || e.ModifiedAt.IsZero() {
+				e.hash = hash
+				e.ModifiedAt = fetchedAt
+			}
+			e.Events = events
+			e.FetchedAt = fetchedAt
+			c.entries[key] = e
+			inflight.entry = e.Entry
+		} else if !e.FetchedAt.IsZero() {
+			// Upstream is failing: fall back to the last good timetable
+			logger.Ctx(fetchCtx).Warn().
+				Err(err).
+				Time("fetchedAt", e.FetchedAt).
+				Msg("Refresh failed, falling back to cached timetable")
+			inflight.entry = e.Entry
+			err = nil
+		} else {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+
+		inflight.err = err
+		close(inflight.done)
+	}()
+
+	return inflight
+}
+
+// sweep drops entries older than the maximum staleness, at most once per TTL.
+// The caller must hold the lock.
+func (c *Cache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+
+	for key, e := range c.entries {
+		if e.inflight == nil && now.Sub(e.FetchedAt) >= c.maxStale {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// hashEvents returns a digest of a timetable used to detect changes between fetches
+func hashEvents(events []types.Event) string {
+	data, _ := json.Marshal(events)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
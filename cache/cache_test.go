@@ -0,0 +1,281 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cpe/calendar/types"
+)
+
+var errUpstream = errors.New("upstream is down")
+
+// timetable returns a timetable whose single event starts at date
+func timetable(date string) []types.Event {
+	return []types.Event{{DateDebut: date}}
+}
+
+// fetcher counts its calls and returns the given timetable or error
+func fetcher(calls *atomic.Int32, events []types.Event, err error) FetchFunc {
+	return func(context.Context) ([]types.Event, error) {
+		calls.Add(1)
+		return events, err
+	}
+}
+
+// age moves the last fetch of key back by d
+func age(c *Cache, key string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key].FetchedAt = c.entries[key].FetchedAt.Add(-d)
+}
+
+// wait blocks until the fetch running for key, if any, is done
+func wait(c *Cache, key string) {
+	c.mu.Lock()
+	var inflight *call
+	if e, ok := c.entries[key]; ok {
+		inflight = e.inflight
+	}
+	c.mu.Unlock()
+	if inflight != nil {
+		<-inflight.done
+	}
+}
+
+func date(t *testing.T, e Entry) string {
+	t.Helper()
+	if len(e.Events) != 1 {
+		t.Fatalf("got %d events, want 1", len(e.Events))
+	}
+	return e.Events[0].DateDebut
+}
+
+func TestGetServesFreshEntries(t *testing.T) {
+	c := New(time.Hour, 2*time.Hour)
+	ctx := context.Background()
+	var calls atomic.Int32
+
+	first, err := c.Get(ctx, "key", fetcher(&calls, timetable("2025-02-17"), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Get(ctx, "key", fetcher(&calls, timetable("2025-02-18"), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("fetched %d times, want 1", calls.Load())
+	}
+	if date(t, second) != "2025-02-17" || !second.FetchedAt.Equal(first.FetchedAt) {
+		t.Errorf("got %+v, want the first fetch", second)
+	}
+
+	// Other keys are fetched separately
+	if _, err := c.Get(ctx, "other", fetcher(&calls, timetable("2025-02-18"), nil)); err != nil || calls.Load() != 2 {
+		t.Errorf("other key: fetched %d times, %v", calls.Load(), err)
+	}
+}
+
+func TestGetRefreshesStaleEntriesInBackground(t *testing.T) {
+	c := New(time.Hour, 2*time.Hour)
+	ctx := context.Background()
+	var calls atomic.Int32
+
+	first, err := c.Get(ctx, "key", fetcher(&calls, timetable("2025-02-17"), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	age(c, "key", 90*time.Minute)
+
+	// The stale entry is served without waiting for the refresh
+	release := make(chan struct{})
+	refresh := func(context.Context) ([]types.Event, error) {
+		<-release
+		calls.Add(1)
+		return timetable("2025-02-18"), nil
+	}
+	stale, err := c.Get(ctx, "key", refresh)
+	if err != nil || date(t, stale) != "2025-02-17" {
+		t.Fatalf("got %+v, %v, want the stale entry", stale, err)
+	}
+	// A single refresh runs however many requests see the stale entry
+	if _, err := c.Get(ctx, "key", refresh); err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	wait(c, "key")
+	refreshed, err := c.Get(ctx, "key", fetcher(&calls, nil, errUpstream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("fetched %d times, want 2", calls.Load())
+	}
+	if date(t, refreshed) != "2025-02-18" || !refreshed.ModifiedAt.After(first.ModifiedAt) {
+		t.Errorf("got %+v, want the refreshed timetable", refreshed)
+	}
+}
+
+func TestGetKeepsModifiedAtWhenUnchanged(t *testing.T) {
+	c := New(time.Hour, 2*time.Hour)
+	ctx := context.Background()
+	var calls atomic.Int32
+
+	first, err := c.Get(ctx, "key", fetcher(&calls, timetable("2025-02-17"), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	age(c, "key", 90*time.Minute)
+	if _, err := c.Get(ctx, "key", fetcher(&calls, timetable("2025-02-17"), nil)); err != nil {
+		t.Fatal(err)
+	}
+	wait(c, "key")
+
+	second, err := c.Get(ctx, "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.FetchedAt.After(first.FetchedAt) || !second.ModifiedAt.Equal(first.ModifiedAt) {
+		t.Errorf("fetched at %s modified at %s, want a new fetch of an unchanged timetable modified at %s",
+			second.FetchedAt, second.ModifiedAt, first.ModifiedAt)
+	}
+}
+
+func TestGetCoalescesConcurrentMisses(t *testing.T) {
+	c := New(time.Hour, 2*time.Hour)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fetch := func(context.Context) ([]types.Event, error) {
+		calls.Add(1)
+		<-release
+		return timetable("2025-02-17"), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]Entry, 10)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = c.Get(context.Background(), "key", fetch)
+		}()
+	}
+
+	// Callers arriving after the fetch get the fresh entry, so there is one call either way
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("fetched %d times, want 1", calls.Load())
+	}
+	for i := range results {
+		if errs[i] != nil || date(t, results[i]) != "2025-02-17" {
+			t.Errorf("caller %d: got %+v, %v", i, results[i], errs[i])
+		}
+	}
+}
+
+func TestGetStopsWaitingWhenCanceled(t *testing.T) {
+	c := New(time.Hour, 2*time.Hour)
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) ([]types.Event, error) {
+		<-release
+		// The shared fetch outlives the request that started it
+		return timetable("2025-02-17"), ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Get(ctx, "key", fetch); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+	wait(c, "key")
+	if e, err := c.Get(context.Background(), "key", nil); err != nil || date(t, e) != "2025-02-17" {
+		t.Errorf("got %+v, %v, want the timetable of the canceled request", e, err)
+	}
+}
+
+func TestGetFallsBackToStaleEntries(t *testing.T) {
+	c := New(time.Hour, 2*time.Hour)
+	ctx := context.Background()
+	var calls atomic.Int32
+
+	first, err := c.Get(ctx, "key", fetcher(&calls, timetable("2025-02-17"), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	age(c, "key", 90*time.Minute)
+
+	// The failed refresh keeps the last good timetable until max_stale
+	if _, err := c.Get(ctx, "key", fetcher(&calls, nil, errUpstream)); err != nil {
+		t.Fatal(err)
+	}
+	wait(c, "key")
+	stale, err := c.Get(ctx, "key", fetcher(&calls, nil, errUpstream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait(c, "key")
+	if calls.Load() != 3 {
+		t.Errorf("fetched %d times, want a refresh per request while failing", calls.Load())
+	}
+	if date(t, stale) != "2025-02-17" || !stale.FetchedAt.Before(first.FetchedAt) {
+		t.Errorf("got %+v, want the last good timetable", stale)
+	}
+
+	// Without a previous timetable the error is returned and nothing is cached
+	if _, err := c.Get(ctx, "missing", fetcher(&calls, nil, errUpstream)); !errors.Is(err, errUpstream) {
+		t.Errorf("got %v, want %v", err, errUpstream)
+	}
+	c.mu.Lock()
+	_, cached := c.entries["missing"]
+	c.mu.Unlock()
+	if cached {
+		t.Error("failed fetch left an entry behind")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	c := New(time.Hour, 2*time.Hour)
+	ctx := context.Background()
+	var calls atomic.Int32
+
+	for _, key := range []string{"expired", "stale", "fresh"} {
+		if _, err := c.Get(ctx, key, fetcher(&calls, timetable("2025-02-17"), nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	age(c, "expired", 3*time.Hour)
+	age(c, "stale", 90*time.Minute)
+
+	// Sweeps run at most once per TTL
+	if _, err := c.Get(ctx, "fresh", nil); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	swept := len(c.entries) != 3
+	c.lastSweep = c.lastSweep.Add(-time.Hour)
+	c.mu.Unlock()
+	if swept {
+		t.Fatal("swept before the TTL")
+	}
+
+	if _, err := c.Get(ctx, "fresh", nil); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries["expired"]; ok || len(c.entries) != 2 {
+		t.Errorf("got %d entries, want only the expired one evicted", len(c.entries))
+	}
+}
@@ -0,0 +1,40 @@
+// Command mockcpe serves the mycpe mobile API from fixture files, for local development:
+//
+//	go run ./cmd/mockcpe -addr :8081
+//	MYCPE_BASE_URL=http://localhost:8081 go run main.go
+//
+// Log in with one of the accounts of mockcpe/fixtures/users.json, e.g. student@cpe.fr / password.
+package main
+
+import (
+	"flag"
+	"io/fs"
+	"net/http"
+	"os"
+
+	"cpe/calendar/logger"
+	"cpe/calendar/mockcpe"
+)
+
+func main() {
+	addr := flag.String("addr", ":8081", "listen address")
+	fixtures := flag.String("fixtures", "", "directory containing users.json and planning fixtures (embedded fixtures when empty)")
+	fixedDates := flag.Bool("fixed-dates", false, "serve the fixture dates as written instead of moving them to the current weeks")
+	flag.Parse()
+
+	var files fs.FS = mockcpe.Fixtures()
+	if *fixtures != "" {
+		files = os.DirFS(*fixtures)
+	}
+
+	server, err := mockcpe.NewServer(files)
+	if err != nil {
+		logger.Log.Fatal().Err(err).Msg("Error loading fixtures")
+	}
+	server.FixedDates = *fixedDates
+
+	logger.Log.Info().Str("addr", *addr).Msg("Starting mock mycpe server")
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		logger.Log.Fatal().Err(err).Msg("Error starting mock server")
+	}
+}
@@ -0,0 +1,402 @@
+// Package config loads the application settings once at startup.
+// Values come from the defaults, then an optional YAML file, then the environment
+// (a .env file included), each source overriding the previous one.
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+
+	"cpe/calendar/ical"
+	"cpe/calendar/logger"
+	"cpe/calendar/request"
+	"cpe/calendar/vault"
+	"cpe/calendar/window"
+)
+
+// Config holds every setting of the application
+type Config struct {
+	Server      Server      `yaml:"server"`
+	Credentials Credentials `yaml:"credentials"`
+	Calendar    Calendar    `yaml:"calendar"`
+	Window      Window      `yaml:"window"`
+	Cache       Cache       `yaml:"cache"`
+	History     History     `yaml:"history"`
+	Vault       Vault       `yaml:"vault"`
+	Upstream    Upstream    `yaml:"upstream"`
+	Log         Log         `yaml:"log"`
+	Features    Features    `yaml:"features"`
+}
+
+// Server configures the HTTP server
+type Server struct {
+	// Listen is the address the server binds, e.g. ":8080"
+	Listen string `yaml:"listen"`
+	// StaticDir holds index.html and the other static files
+	StaticDir string `yaml:"static_dir"`
+}
+
+// Credentials configures how subscription links carry the mycpe credentials
+type Credentials struct {
+	// PrivateKeys are the PEM files used to decrypt the credentials. The first one is current:
+	// its public key encrypts new links, the others only keep older links working.
+	PrivateKeys []string `yaml:"private_keys"`
+	// Separator splits the username from the password of legacy links, newer ones carry JSON
+	Separator string `yaml:"separator"`
+}
+
+// Calendar configures the generated feeds
+type Calendar struct {
+	// Timezone is the IANA zone mycpe dates are expressed in
+	Timezone string `yaml:"timezone"`
+	// LocalTime emits local times with a VTIMEZONE instead of UTC
+	LocalTime bool `yaml:"local_time"`
+	// UIDDomain is the domain of event UIDs, a fixed default when empty so UIDs never depend on the requested host
+	UIDDomain string `yaml:"uid_domain"`
+	// MultiDay writes multi-day interventions as one timed event (span) or one all-day event (all_day)
+	MultiDay string `yaml:"multi_day"`
+	// EmptyDays drops the empty day placeholders (drop) or writes them as free days (free_day)
+	EmptyDays string `yaml:"empty_days"`
+	// Breaks drops breaks (drop) or writes them as events not making the subscriber busy (transparent)
+	Breaks string `yaml:"breaks"`
+
+	// Location is Timezone once loaded by Validate
+	Location *time.Location `yaml:"-"`
+}
+
+// Window configures the default date window, see window.Policy
+type Window struct {
+	Mode        string `yaml:"mode"`
+	DaysBack    int    `yaml:"days_back"`
+	DaysForward int    `yaml:"days_forward"`
+	// Cutover is the first day of the academic year as MM-DD
+	Cutover string `yaml:"cutover"`
+	MaxDays int    `yaml:"max_days"`
+}
+
+// Cache configures the in-memory timetable cache
+type Cache struct {
+	// TTL is how long a timetable is served without refreshing it
+	TTL time.Duration `yaml:"ttl"`
+	// MaxStale is how long a timetable is still served while mycpe is failing
+	MaxStale time.Duration `yaml:"max_stale"`
+}
+
+// History configures the change tracking store
+type History struct {
+	Dir string `yaml:"dir"`
+	// Grace is how long a vanished event is served as cancelled
+	Grace time.Duration `yaml:"grace"`
+	// SubscriberSalt is mixed into the history and vault keys of subscribers, see history.SubscriberKey.
+	// Changing it starts every history over and detaches stored feeds from their owners.
+	SubscriberSalt string `yaml:"subscriber_salt"`
+}
+
+// Vault configures the server side credential store behind /cal/<token>.ics links
+type Vault struct {
+	Dir string `yaml:"dir"`
+	// Key is the base64 encoded 32 bytes key encrypting the stored credentials
+	Key string `yaml:"key"`
+}
+
+// Upstream configures the mycpe client, see request.Config
+type Upstream struct {
+	BaseURL          string        `yaml:"base_url"`
+	UserAgent        string        `yaml:"user_agent"`
+	Timeout          time.Duration `yaml:"timeout"`
+	LoginTimeout     time.Duration `yaml:"login_timeout"`
+	PlanningTimeout  time.Duration `yaml:"planning_timeout"`
+	TokenLifetime    time.Duration `yaml:"token_lifetime"`
+	MaxAttempts      int           `yaml:"max_attempts"`
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+	OmitRequestID    bool          `yaml:"omit_request_id"`
+}
+
+// Log configures the logger, see logger.Config
+type Log struct {
+	Level      string `yaml:"level"`
+	Output     string `yaml:"output"`
+	Format     string `yaml:"format"`
+	File       string `yaml:"file"`
+	MaxSizeMB  int    `yaml:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups"`
+	UserSalt   string `yaml:"user_salt"`
+}
+
+// Features toggles optional behaviors
+type Features struct {
+	// History tracks schedule changes to emit SEQUENCE and cancelled events
+	History bool `yaml:"history"`
+	// CredentialsNotice serves a calendar asking to renew the link when mycpe rejects the credentials
+	CredentialsNotice bool `yaml:"credentials_notice"`
+	// Vault enables POST /cal, which stores the credentials and returns an opaque token link
+	Vault bool `yaml:"vault"`
+}
+
+// Default returns the settings used when nothing overrides them
+func Default() Config {
+	policy := window.DefaultPolicy()
+	logConfig := logger.DefaultConfig()
+
+	return Config{
+		Server: Server{
+			Listen:    ":8080",
+			StaticDir: "static",
+		},
+		Credentials: Credentials{
+			PrivateKeys: []string{"secret/private.pem"},
+			Separator:   "__|__",
+		},
+		Calendar: Calendar{
+			Timezone:  "Europe/Paris",
+			MultiDay:  ical.MultiDaySpan,
+			EmptyDays: ical.EmptyDaysDrop,
+			Breaks:    ical.BreaksDrop,
+		},
+		Window: Window{
+			Mode:        policy.Mode,
+			DaysBack:    policy.DaysBack,
+			DaysForward: policy.DaysForward,
+			Cutover:     fmt.Sprintf("%02d-%02d", policy.CutoverMonth, policy.CutoverDay),
+			MaxDays:     policy.MaxDays,
+		},
+		Cache: Cache{
+			TTL:      15 * time.Minute,
+			MaxStale: 24 * time.Hour,
+		},
+		History: History{
+			Dir:   "data/history",
+			Grace: 7 * 24 * time.Hour,
+		},
+		Vault: Vault{
+			Dir: "data/vault",
+		},
+		Upstream: Upstream{
+			BaseURL:          request.DefaultBaseURL,
+			UserAgent:        request.DefaultUserAgent,
+			Timeout:          request.DefaultTimeout,
+			LoginTimeout:     request.DefaultLoginTimeout,
+			PlanningTimeout:  request.DefaultPlanningTimeout,
+			TokenLifetime:    request.DefaultTokenLifetime,
+			MaxAttempts:      request.DefaultMaxAttempts,
+			BreakerThreshold: request.DefaultBreakerThreshold,
+			BreakerCooldown:  request.DefaultBreakerCooldown,
+		},
+		Log: Log{
+			Level:      logConfig.Level,
+			Output:     logConfig.Output,
+			Format:     logConfig.Format,
+			File:       logConfig.File,
+			MaxSizeMB:  logConfig.MaxSizeMB,
+			MaxBackups: logConfig.MaxBackups,
+		},
+		Features: Features{
+			History:           true,
+			CredentialsNotice: true,
+		},
+	}
+}
+
+// Load reads the .env file, the YAML file at path (CONFIG_FILE when path is empty, none when both are)
+// and the environment, then validates the result
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	// .env only sets variables missing from the real environment
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return cfg, fmt.Errorf("failed to load .env: %w", err)
+	}
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(&cfg, path); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// loadFile overrides cfg with the YAML file at path, unknown keys are rejected to catch typos
+func loadFile(cfg *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks every setting, reporting all the problems at once, and loads the time zone
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Listen == "" {
+		invalid("server.listen must not be empty")
+	}
+	if len(c.Credentials.PrivateKeys) == 0 {
+		invalid("credentials.private_keys must list at least one key")
+	}
+	for _, path := range c.Credentials.PrivateKeys {
+		if path == "" {
+			invalid("credentials.private_keys must not contain empty paths")
+		}
+	}
+
+	loc, err := time.LoadLocation(c.Calendar.Timezone)
+	if err != nil {
+		invalid("calendar.timezone %q: %v", c.Calendar.Timezone, err)
+	}
+	c.Calendar.Location = loc
+
+	if c.Calendar.MultiDay != ical.MultiDaySpan && c.Calendar.MultiDay != ical.MultiDayAllDay {
+		invalid("calendar.multi_day %q must be %s or %s", c.Calendar.MultiDay, ical.MultiDaySpan, ical.MultiDayAllDay)
+	}
+	if c.Calendar.EmptyDays != ical.EmptyDaysDrop && c.Calendar.EmptyDays != ical.EmptyDaysFreeDay {
+		invalid("calendar.empty_days %q must be %s or %s", c.Calendar.EmptyDays, ical.EmptyDaysDrop, ical.EmptyDaysFreeDay)
+	}
+	if c.Calendar.Breaks != ical.BreaksDrop && c.Calendar.Breaks != ical.BreaksTransparent {
+		invalid("calendar.breaks %q must be %s or %s", c.Calendar.Breaks, ical.BreaksDrop, ical.BreaksTransparent)
+	}
+
+	if c.Window.Mode != window.ModeRolling && c.Window.Mode != window.ModeAcademic {
+		invalid("window.mode %q must be %s or %s", c.Window.Mode, window.ModeRolling, window.ModeAcademic)
+	}
+	if c.Window.DaysBack < 0 || c.Window.DaysForward < 0 {
+		invalid("window.days_back and window.days_forward must not be negative")
+	}
+	if c.Window.MaxDays <= 0 {
+		invalid("window.max_days must be positive")
+	}
+	if _, err := time.Parse("01-02", c.Window.Cutover); err != nil {
+		invalid("window.cutover %q must be MM-DD", c.Window.Cutover)
+	}
+
+	if c.Cache.TTL <= 0 {
+		invalid("cache.ttl must be positive")
+	}
+	if c.Cache.MaxStale < c.Cache.TTL {
+		invalid("cache.max_stale must not be shorter than cache.ttl")
+	}
+
+	if c.Features.History && c.History.Dir == "" {
+		invalid("history.dir must not be empty when the history feature is enabled")
+	}
+	if c.History.Grace < 0 {
+		invalid("history.grace must not be negative")
+	}
+
+	if c.Features.Vault {
+		if c.Vault.Dir == "" {
+			invalid("vault.dir must not be empty when the vault feature is enabled")
+		}
+		if key, err := base64.StdEncoding.DecodeString(c.Vault.Key); err != nil || len(key) != vault.KeySize {
+			invalid("vault.key (VAULT_KEY) must be %d base64 encoded bytes, e.g. from openssl rand -base64 %d", vault.KeySize, vault.KeySize)
+		}
+	}
+
+	if !strings.HasPrefix(c.Upstream.BaseURL, "http://") && !strings.HasPrefix(c.Upstream.BaseURL, "https://") {
+		invalid("upstream.base_url %q must be an http(s) URL", c.Upstream.BaseURL)
+	}
+	for name, value := range map[string]time.Duration{
+		"timeout":          c.Upstream.Timeout,
+		"login_timeout":    c.Upstream.LoginTimeout,
+		"planning_timeout": c.Upstream.PlanningTimeout,
+		"token_lifetime":   c.Upstream.TokenLifetime,
+		"breaker_cooldown": c.Upstream.BreakerCooldown,
+	} {
+		if value <= 0 {
+			invalid("upstream.%s must be positive", name)
+		}
+	}
+	if c.Upstream.MaxAttempts <= 0 || c.Upstream.BreakerThreshold <= 0 {
+		invalid("upstream.max_attempts and upstream.breaker_threshold must be positive")
+	}
+
+	if c.Log.MaxSizeMB < 0 || c.Log.MaxBackups < 0 {
+		invalid("log.max_size_mb and log.max_backups must not be negative")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// VaultKey returns the decoded vault key, Validate must have succeeded
+func (c Config) VaultKey() []byte {
+	key, _ := base64.StdEncoding.DecodeString(c.Vault.Key)
+	return key
+}
+
+// WindowPolicy returns the date window policy, Validate must have succeeded
+func (c Config) WindowPolicy() window.Policy {
+	cutover, _ := time.Parse("01-02", c.Window.Cutover)
+
+	return window.Policy{
+		Mode:         c.Window.Mode,
+		DaysBack:     c.Window.DaysBack,
+		DaysForward:  c.Window.DaysForward,
+		CutoverMonth: cutover.Month(),
+		CutoverDay:   cutover.Day(),
+		MaxDays:      c.Window.MaxDays,
+		Location:     c.Calendar.Location,
+	}
+}
+
+// UpstreamConfig returns the mycpe client configuration
+func (c Config) UpstreamConfig() request.Config {
+	return request.Config{
+		BaseURL:          c.Upstream.BaseURL,
+		UserAgent:        c.Upstream.UserAgent,
+		Timeout:          c.Upstream.Timeout,
+		LoginTimeout:     c.Upstream.LoginTimeout,
+		PlanningTimeout:  c.Upstream.PlanningTimeout,
+		TokenLifetime:    c.Upstream.TokenLifetime,
+		MaxAttempts:      c.Upstream.MaxAttempts,
+		BreakerThreshold: c.Upstream.BreakerThreshold,
+		BreakerCooldown:  c.Upstream.BreakerCooldown,
+		OmitRequestID:    c.Upstream.OmitRequestID,
+	}
+}
+
+// LoggerConfig returns the logger configuration
+func (c Config) LoggerConfig() logger.Config {
+	return logger.Config{
+		Level:      c.Log.Level,
+		Output:     c.Log.Output,
+		Format:     c.Log.Format,
+		File:       c.Log.File,
+		MaxSizeMB:  c.Log.MaxSizeMB,
+		MaxBackups: c.Log.MaxBackups,
+		UserSalt:   c.Log.UserSalt,
+	}
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cpe/calendar/ical"
+	"cpe/calendar/window"
+)
+
+// writeConfig writes a YAML configuration file and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  listen: ":9000"
+cache:
+  ttl: 5m
+  max_stale: 1h
+window:
+  mode: academic
+`)
+	t.Setenv("CACHE_TTL", "10m")
+	t.Setenv("WINDOW_CUTOVER", "08-25")
+	// Empty variables are ignored
+	t.Setenv("LISTEN_ADDR", "")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := Default()
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{name: "environment over YAML", got: cfg.Cache.TTL, want: 10 * time.Minute},
+		{name: "environment over defaults", got: cfg.Window.Cutover, want: "08-25"},
+		{name: "YAML over defaults", got: cfg.Cache.MaxStale, want: time.Hour},
+		{name: "YAML under an empty variable", got: cfg.Server.Listen, want: ":9000"},
+		{name: "YAML mode", got: cfg.Window.Mode, want: window.ModeAcademic},
+		{name: "defaults", got: cfg.Calendar.Timezone, want: defaults.Calendar.Timezone},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	policy := cfg.WindowPolicy()
+	if policy.CutoverMonth != time.August || policy.CutoverDay != 25 || policy.Location.String() != "Europe/Paris" {
+		t.Errorf("got policy %+v", policy)
+	}
+}
+
+func TestLoadFromConfigFileVariable(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfig(t, "calendar:\n  multi_day: all_day\n"))
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Calendar.MultiDay != ical.MultiDayAllDay {
+		t.Errorf("got %q, want the CONFIG_FILE value", cfg.Calendar.MultiDay)
+	}
+}
+
+func TestLoadRejectsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "multi_day", yaml: "calendar:\n  multi_day: weekly\n", wantErr: `calendar.multi_day "weekly"`},
+		{name: "multi_day from the environment", env: map[string]string{"ICS_MULTI_DAY": "weekly"}, wantErr: `calendar.multi_day "weekly"`},
+		{name: "window mode", yaml: "window:\n  mode: semester\n", wantErr: `window.mode "semester"`},
+		{name: "window mode from the environment", env: map[string]string{"WINDOW_MODE": "semester"}, wantErr: `window.mode "semester"`},
+		{name: "cutover month", yaml: "window:\n  cutover: 13-01\n", wantErr: `window.cutover "13-01"`},
+		{name: "cutover format", env: map[string]string{"WINDOW_CUTOVER": "September"}, wantErr: `window.cutover "September"`},
+		{name: "unknown key", yaml: "window:\n  cut_over: 09-01\n", wantErr: "field cut_over not found"},
+		{name: "unparsable variable", env: map[string]string{"CACHE_TTL": "15"}, wantErr: `CACHE_TTL="15"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			path := ""
+			if tt.yaml != "" {
+				path = writeConfig(t, tt.yaml)
+			}
+
+			_, err := Load(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error mentioning %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := Default()
+	cfg.Calendar.MultiDay = "weekly"
+	cfg.Window.Mode = "semester"
+	cfg.Window.Cutover = "9-1-2025"
+	cfg.Cache.MaxStale = time.Minute
+	cfg.Upstream.BaseURL = "mycpe.cpe.fr"
+	cfg.Features.Vault = true
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, want := range []string{
+		"calendar.multi_day",
+		"window.mode",
+		"window.cutover",
+		"cache.max_stale",
+		"vault.key",
+		"upstream.base_url",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not report %s:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadEnvReportsEveryProblem(t *testing.T) {
+	t.Setenv("CACHE_TTL", "soon")
+	t.Setenv("WINDOW_DAYS_BACK", "a week")
+	t.Setenv("FEATURE_VAULT", "maybe")
+
+	err := loadEnv(&Config{})
+	if err == nil {
+		t.Fatal("invalid environment accepted")
+	}
+	for _, want := range []string{"CACHE_TTL", "WINDOW_DAYS_BACK", "FEATURE_VAULT"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not report %s:\n%v", want, err)
+		}
+	}
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadEnv overrides cfg with the environment variables that are set
+func loadEnv(cfg *Config) error {
+	env := &envReader{}
+
+	env.str("LISTEN_ADDR", &cfg.Server.Listen)
+	env.str("STATIC_DIR", &cfg.Server.StaticDir)
+
+	env.list("PRIVATE_KEY_FILES", &cfg.Credentials.PrivateKeys)
+	env.str("SEPARATOR", &cfg.Credentials.Separator)
+
+	env.str("TIMEZONE", &cfg.Calendar.Timezone)
+	env.boolean("ICS_LOCAL_TIME", &cfg.Calendar.LocalTime)
+	env.str("UID_DOMAIN", &cfg.Calendar.UIDDomain)
+	env.str("ICS_MULTI_DAY", &cfg.Calendar.MultiDay)
+	env.str("ICS_EMPTY_DAYS", &cfg.Calendar.EmptyDays)
+	env.str("ICS_BREAKS", &cfg.Calendar.Breaks)
+
+	env.str("WINDOW_MODE", &cfg.Window.Mode)
+	env.integer("WINDOW_DAYS_BACK", &cfg.Window.DaysBack)
+	env.integer("WINDOW_DAYS_FORWARD", &cfg.Window.DaysForward)
+	env.str("WINDOW_CUTOVER", &cfg.Window.Cutover)
+	env.integer("WINDOW_MAX_DAYS", &cfg.Window.MaxDays)
+
+	env.duration("CACHE_TTL", &cfg.Cache.TTL)
+	env.duration("CACHE_MAX_STALE", &cfg.Cache.MaxStale)
+
+	env.str("HISTORY_DIR", &cfg.History.Dir)
+	env.duration("HISTORY_GRACE", &cfg.History.Grace)
+	env.str("HISTORY_SUBSCRIBER_SALT", &cfg.History.SubscriberSalt)
+
+	env.str("VAULT_DIR", &cfg.Vault.Dir)
+	env.str("VAULT_KEY", &cfg.Vault.Key)
+
+	env.str("MYCPE_BASE_URL", &cfg.Upstream.BaseURL)
+	env.str("MYCPE_USER_AGENT", &cfg.Upstream.UserAgent)
+	env.duration("MYCPE_TIMEOUT", &cfg.Upstream.Timeout)
+	env.duration("MYCPE_LOGIN_TIMEOUT", &cfg.Upstream.LoginTimeout)
+	env.duration("MYCPE_PLANNING_TIMEOUT", &cfg.Upstream.PlanningTimeout)
+	env.duration("MYCPE_TOKEN_LIFETIME", &cfg.Upstream.TokenLifetime)
+	env.integer("MYCPE_MAX_ATTEMPTS", &cfg.Upstream.MaxAttempts)
+	env.integer("MYCPE_BREAKER_THRESHOLD", &cfg.Upstream.BreakerThreshold)
+	env.duration("MYCPE_BREAKER_COOLDOWN", &cfg.Upstream.BreakerCooldown)
+	env.boolean("MYCPE_OMIT_REQUEST_ID", &cfg.Upstream.OmitRequestID)
+
+	env.str("LOG_LEVEL", &cfg.Log.Level)
+	env.str("LOG_OUTPUT", &cfg.Log.Output)
+	env.str("LOG_FORMAT", &cfg.Log.Format)
+	env.str("LOG_FILE", &cfg.Log.File)
+	env.integer("LOG_MAX_SIZE_MB", &cfg.Log.MaxSizeMB)
+	env.integer("LOG_MAX_BACKUPS", &cfg.Log.MaxBackups)
+	env.str("LOG_USER_SALT", &cfg.Log.UserSalt)
+
+	env.boolean("FEATURE_HISTORY", &cfg.Features.History)
+	env.boolean("FEATURE_CREDENTIALS_NOTICE", &cfg.Features.CredentialsNotice)
+	env.boolean("FEATURE_VAULT", &cfg.Features.Vault)
+
+	if len(env.errs) > 0 {
+		return fmt.Errorf("invalid environment:\n%w", errors.Join(env.errs...))
+	}
+	return nil
+}
+
+// envReader assigns set variables to their target, collecting parse errors.
+// Empty variables are ignored so docker-compose can pass unset ones through.
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) str(name string, target *string) {
+	if raw := os.Getenv(name); raw != "" {
+		*target = raw
+	}
+}
+
+// list splits a comma separated variable
+func (e *envReader) list(name string, target *[]string) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return
+	}
+	values := strings.Split(raw, ",")
+	for i, value := range values {
+		values[i] = strings.TrimSpace(value)
+	}
+	*target = values
+}
+
+func (e *envReader) integer(name string, target *int) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s=%q is not an integer", name, raw))
+		return
+	}
+	*target = value
+}
+
+func (e *envReader) boolean(name string, target *bool) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s=%q is not a boolean", name, raw))
+		return
+	}
+	*target = value
+}
+
+func (e *envReader) duration(name string, target *time.Duration) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s=%q is not a duration such as 15m or 24h", name, raw))
+		return
+	}
+	*target = value
+}
@@ -0,0 +1,60 @@
+package decrypt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CredentialsVersion is the version of the JSON payload written by static/index.html
+const CredentialsVersion = 1
+
+// ErrInvalidCredentials is returned when a decrypted payload cannot be parsed
+var ErrInvalidCredentials = errors.New("invalid credentials payload")
+
+// Credentials is the decrypted content of a subscription link
+type Credentials struct {
+	Version  int    `json:"v"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// CreatedAt is when the link was generated, zero for legacy links
+	CreatedAt time.Time `json:"created_at"`
+	Options   Options   `json:"options"`
+}
+
+// Options are the feed settings chosen when the link was generated
+type Options struct {
+	// From and To override the default date window, as YYYY-MM-DD
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// ParseCredentials parses a decrypted payload: a JSON document, or for legacy links
+// the username and the password joined by separator
+func ParseCredentials(plaintext, separator string) (Credentials, error) {
+	if strings.HasPrefix(strings.TrimSpace(plaintext), "{") {
+		var creds Credentials
+		if err := json.Unmarshal([]byte(plaintext), &creds); err != nil {
+			return Credentials{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+		}
+		if creds.Version != CredentialsVersion {
+			return Credentials{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidCredentials, creds.Version)
+		}
+		if creds.Username == "" || creds.Password == "" {
+			return Credentials{}, fmt.Errorf("%w: missing username or password", ErrInvalidCredentials)
+		}
+		return creds, nil
+	}
+
+	// Legacy links: everything after the first separator is the password, which may contain it
+	if separator == "" {
+		return Credentials{}, fmt.Errorf("%w: no separator configured for legacy links", ErrInvalidCredentials)
+	}
+	username, password, ok := strings.Cut(plaintext, separator)
+	if !ok || username == "" || password == "" {
+		return Credentials{}, fmt.Errorf("%w: separator not found", ErrInvalidCredentials)
+	}
+	return Credentials{Username: username, Password: password}, nil
+}
@@ -0,0 +1,90 @@
+package decrypt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCredentials(t *testing.T) {
+	const separator = "__|__"
+	createdAt := time.Date(2025, 2, 17, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		plaintext string
+		want      Credentials
+	}{
+		{
+			name:      "json",
+			plaintext: `{"v":1,"username":"student@cpe.fr","password":"password","created_at":"2025-02-17T12:00:00Z","options":{"from":"2025-02-01","to":"2025-03-01"}}`,
+			want: Credentials{Version: 1, Username: "student@cpe.fr", Password: "password", CreatedAt: createdAt,
+				Options: Options{From: "2025-02-01", To: "2025-03-01"}},
+		},
+		{
+			name:      "json password containing the separator",
+			plaintext: `{"v":1,"username":"student@cpe.fr","password":"pass__|__word"}`,
+			want:      Credentials{Version: 1, Username: "student@cpe.fr", Password: "pass__|__word"},
+		},
+		{
+			name:      "json with surrounding spaces",
+			plaintext: " \n{\"v\":1,\"username\":\"student@cpe.fr\",\"password\":\"password\"}",
+			want:      Credentials{Version: 1, Username: "student@cpe.fr", Password: "password"},
+		},
+		{
+			name:      "legacy",
+			plaintext: "student@cpe.fr__|__password",
+			want:      Credentials{Username: "student@cpe.fr", Password: "password"},
+		},
+		{
+			// Everything after the first separator is the password
+			name:      "legacy password containing the separator",
+			plaintext: "student@cpe.fr__|__pass__|__word",
+			want:      Credentials{Username: "student@cpe.fr", Password: "pass__|__word"},
+		},
+		{
+			name:      "legacy password starting with a brace",
+			plaintext: "student@cpe.fr__|__{password}",
+			want:      Credentials{Username: "student@cpe.fr", Password: "{password}"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCredentials(tt.plaintext, separator)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Version != tt.want.Version || got.Username != tt.want.Username || got.Password != tt.want.Password ||
+				!got.CreatedAt.Equal(tt.want.CreatedAt) || got.Options != tt.want.Options {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCredentialsErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext string
+		separator string
+	}{
+		{name: "unsupported version", plaintext: `{"v":2,"username":"student@cpe.fr","password":"password"}`, separator: "__|__"},
+		{name: "missing version", plaintext: `{"username":"student@cpe.fr","password":"password"}`, separator: "__|__"},
+		{name: "missing username", plaintext: `{"v":1,"password":"password"}`, separator: "__|__"},
+		{name: "missing password", plaintext: `{"v":1,"username":"student@cpe.fr"}`, separator: "__|__"},
+		{name: "invalid json", plaintext: `{"v":1,"username":`, separator: "__|__"},
+		{name: "legacy without separator", plaintext: "student@cpe.fr password", separator: "__|__"},
+		{name: "legacy without password", plaintext: "student@cpe.fr__|__", separator: "__|__"},
+		{name: "legacy without username", plaintext: "__|__password", separator: "__|__"},
+		{name: "legacy with no separator configured", plaintext: "student@cpe.fr__|__password", separator: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCredentials(tt.plaintext, tt.separator); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("got %v, want %v", err, ErrInvalidCredentials)
+			}
+		})
+	}
+}
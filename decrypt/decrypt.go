@@ -7,52 +7,57 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
 
 	"cpe/calendar/logger"
 )
 
-func DecryptMessage(encryptedBase64 string, privateKey *rsa.PrivateKey) (string, error) {
+// DecryptMessage decrypts a base64 RSA-OAEP message with the first of privateKeys that fits
+func DecryptMessage(encryptedBase64 string, privateKeys ...*rsa.PrivateKey) (string, error) {
 	// Log the decryption attempt with context
 	logger.Log.Info().
-		Str("encryptedBase64", encryptedBase64).
+		Str("encrypted", logger.Fingerprint(encryptedBase64)).
 		Msg("Attempting to decrypt message")
 
 	// Decode the Base64-encoded message
 	encryptedBytes, err := base64.StdEncoding.DecodeString(encryptedBase64)
 	if err != nil {
 		logger.Log.Error().
-			Str("encryptedBase64", encryptedBase64).
+			Str("encrypted", logger.Fingerprint(encryptedBase64)).
 			Err(err).
 			Msg("Failed to decode base64 string")
 		return "", fmt.Errorf("failed to decode base64 string: %v", err)
 	}
 
-	// Create a new SHA-256 hash for OAEP
-	hash := sha256.New()
-
-	// Decrypt the message using the private key
-	decryptedBytes, err := rsa.DecryptOAEP(hash, rand.Reader, privateKey, encryptedBytes, nil)
+	// Decrypt the message using the private keys in turn, OAEP rejects the wrong ones
+	var decryptedBytes []byte
+	err = errors.New("no private key")
+	for _, privateKey := range privateKeys {
+		decryptedBytes, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, encryptedBytes, nil)
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
 		logger.Log.Error().
-			Str("encryptedBase64", encryptedBase64).
+			Str("encrypted", logger.Fingerprint(encryptedBase64)).
 			Err(err).
 			Msg("Failed to decrypt message")
 		return "", fmt.Errorf("failed to decrypt message: %v", err)
 	}
 
 	logger.Log.Info().
-		Str("decryptedMessage", string(decryptedBytes)[:5]).
+		Int("decryptedLength", len(decryptedBytes)).
 		Msg("Message decrypted successfully")
 
 	// Return the decrypted message as a string
 	return string(decryptedBytes), nil
 }
 
-func LoadPrivateKey() (*rsa.PrivateKey, error) {
-	pemFile := "secret/private.pem"
-
+// LoadPrivateKey reads and parses a PEM encoded RSA private key, PKCS#1 or PKCS#8
+func LoadPrivateKey(pemFile string) (*rsa.PrivateKey, error) {
 	// Log the private key loading attempt
 	logger.Log.Info().
 		Str("pemFile", pemFile).
@@ -68,43 +73,50 @@ func LoadPrivateKey() (*rsa.PrivateKey, error) {
 		return nil, fmt.Errorf("failed to read private key file: %v", err)
 	}
 
+	privateKey, err := ParsePrivateKey(keyData)
+	if err != nil {
+		logger.Log.Error().
+			Str("pemFile", pemFile).
+			Err(err).
+			Msg("Failed to parse private key")
+		return nil, fmt.Errorf("%s: %w", pemFile, err)
+	}
+
+	logger.Log.Info().
+		Str("pemFile", pemFile).
+		Msg("Private key loaded successfully")
+
+	return privateKey, nil
+}
+
+// ParsePrivateKey parses a PEM encoded RSA private key, PKCS#1 or PKCS#8
+func ParsePrivateKey(keyData []byte) (*rsa.PrivateKey, error) {
 	// Decode the PEM block
 	block, _ := pem.Decode(keyData)
 	if block == nil {
-		logger.Log.Fatal().
-			Str("pemFile", pemFile).
-			Msg("Failed to decode PEM block containing private key")
+		return nil, errors.New("no PEM block containing a private key")
 	}
 
-	var privateKey interface{}
-	// Parse the private key
-	if block.Type == "PRIVATE KEY" {
+	switch block.Type {
+	case "PRIVATE KEY":
 		// PKCS#8 format
-		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 		if err != nil {
-			logger.Log.Fatal().
-				Str("pemFile", pemFile).
-				Err(err).
-				Msg("Failed to parse PKCS#8 private key")
+			return nil, fmt.Errorf("failed to parse PKCS#8 private key: %w", err)
+		}
+		privateKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("PKCS#8 private key is a %T, not an RSA key", key)
 		}
-	} else if block.Type == "RSA PRIVATE KEY" {
+		return privateKey, nil
+	case "RSA PRIVATE KEY":
 		// PKCS#1 format
-		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 		if err != nil {
-			logger.Log.Fatal().
-				Str("pemFile", pemFile).
-				Err(err).
-				Msg("Failed to parse PKCS#1 private key")
+			return nil, fmt.Errorf("failed to parse PKCS#1 private key: %w", err)
 		}
-	} else {
-		logger.Log.Fatal().
-			Str("pemFile", pemFile).
-			Msg("Unknown PEM block type")
+		return privateKey, nil
+	default:
+		return nil, fmt.Errorf("unknown PEM block type %q", block.Type)
 	}
-
-	logger.Log.Info().
-		Str("pemFile", pemFile).
-		Msg("Private key loaded successfully")
-
-	return privateKey.(*rsa.PrivateKey), nil
 }
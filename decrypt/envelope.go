@@ -0,0 +1,140 @@
+package decrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// EnvelopeVersion is the first byte of the hybrid envelope. Raw RSA-OAEP messages are version 1.
+const EnvelopeVersion = 2
+
+// envelopePrefix starts every envelope, so it is never mistaken for a legacy message.
+// Legacy messages are base64 or start with a hex key ID, neither can begin with "v2.".
+const envelopePrefix = "v2" + keyIDSeparator
+
+const (
+	// envelopeKeyIDSize is the binary size of a key ID
+	envelopeKeyIDSize = 4
+	// envelopeHeaderSize covers the version byte and the key ID, authenticated by GCM
+	envelopeHeaderSize = 1 + envelopeKeyIDSize
+	// envelopeAESKeySize selects AES-256
+	envelopeAESKeySize = 32
+	// envelopeNonceSize is the standard GCM nonce size
+	envelopeNonceSize = 12
+)
+
+// ErrMalformedEnvelope is returned for envelopes too short or carrying an unknown version
+var ErrMalformedEnvelope = errors.New("malformed credentials envelope")
+
+// The envelope is envelopePrefix followed by the URL-safe base64 without padding of:
+//
+//	version (1 byte) | key ID (4 bytes) | RSA-OAEP-SHA256 wrapped AES-256 key (key size) | GCM nonce (12 bytes) | ciphertext and tag
+//
+// The version and key ID are the additional authenticated data of the GCM encryption.
+// static/encryption.js produces the same format in the browser.
+
+// Seal encrypts plaintext into an envelope for key
+func Seal(key Key, plaintext []byte) (string, error) {
+	keyID, err := hex.DecodeString(key.ID)
+	if err != nil || len(keyID) != envelopeKeyIDSize {
+		return "", fmt.Errorf("invalid key ID %q", key.ID)
+	}
+	header := append([]byte{EnvelopeVersion}, keyID...)
+
+	// Encrypt the payload with a fresh AES key
+	aesKey := make([]byte, envelopeAESKeySize)
+	if _, err := rand.Read(aesKey); err != nil {
+		return "", fmt.Errorf("failed to generate AES key: %w", err)
+	}
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, envelopeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	ciphertext := gcm.Seal(nil, nonce, plaintext, header)
+
+	// Wrap the AES key with the RSA public key
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.Private.PublicKey, aesKey, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap AES key: %w", err)
+	}
+
+	envelope := make([]byte, 0, len(header)+len(wrappedKey)+len(nonce)+len(ciphertext))
+	envelope = append(envelope, header...)
+	envelope = append(envelope, wrappedKey...)
+	envelope = append(envelope, nonce...)
+	envelope = append(envelope, ciphertext...)
+	return envelopePrefix + base64.RawURLEncoding.EncodeToString(envelope), nil
+}
+
+// parseEnvelope decodes the base64 part of an envelope, the prefix already removed
+func parseEnvelope(encoded string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedEnvelope, err)
+	}
+	if len(data) < envelopeHeaderSize || data[0] != EnvelopeVersion {
+		return nil, ErrMalformedEnvelope
+	}
+	return data, nil
+}
+
+// openEnvelope decrypts a decoded envelope with the key it names
+func (kr *Keyring) openEnvelope(data []byte) ([]byte, error) {
+	header := data[:envelopeHeaderSize]
+	id := hex.EncodeToString(header[1:])
+	key, found := kr.byID[id]
+	if !found {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+
+	body := data[envelopeHeaderSize:]
+	wrappedSize := key.Private.Size()
+	if len(body) < wrappedSize+envelopeNonceSize {
+		return nil, ErrMalformedEnvelope
+	}
+	wrappedKey := body[:wrappedSize]
+	nonce := body[wrappedSize : wrappedSize+envelopeNonceSize]
+	ciphertext := body[wrappedSize+envelopeNonceSize:]
+
+	// Unwrap the AES key
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key.Private, wrappedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap AES key: %w", err)
+	}
+	if len(aesKey) != envelopeAESKeySize {
+		return nil, ErrMalformedEnvelope
+	}
+
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt envelope: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(aesKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
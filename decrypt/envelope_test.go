@@ -0,0 +1,111 @@
+package decrypt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// oldKey and newKey are shared by the tests, RSA key generation is slow
+var oldKey, newKey *rsa.PrivateKey
+
+func TestMain(m *testing.M) {
+	for _, key := range []**rsa.PrivateKey{&oldKey, &newKey} {
+		var err error
+		if *key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			panic(err)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// legacy encrypts plaintext the way links were before envelopes: raw RSA-OAEP in standard base64
+func legacy(t *testing.T, key *rsa.PrivateKey, plaintext string) string {
+	t.Helper()
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey, []byte(plaintext), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext)
+}
+
+// tamper flips a bit of the decoded envelope at offset, negative offsets counting from the end
+func tamper(t *testing.T, sealed string, offset int) string {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(sealed, envelopePrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset < 0 {
+		offset += len(data)
+	}
+	data[offset] ^= 0x01
+	return envelopePrefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestEnvelope(t *testing.T) {
+	kr, err := NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := `{"v":1,"username":"student@cpe.fr","password":"p@ss.word__|__"}`
+
+	sealed, err := Seal(kr.Current(), []byte(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, envelopePrefix) {
+		t.Fatalf("envelope %q does not start with %q", sealed[:8], envelopePrefix)
+	}
+	unknown, err := Seal(Key{ID: "0badc0de", Private: oldKey}, []byte(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		encrypted string
+		want      string
+		wantErr   error
+	}{
+		{name: "round trip", encrypted: sealed, want: plaintext},
+		{name: "legacy link", encrypted: legacy(t, newKey, "student@cpe.fr__|__password"), want: "student@cpe.fr__|__password"},
+		{name: "tampered version", encrypted: tamper(t, sealed, 0), wantErr: ErrMalformedEnvelope},
+		{name: "tampered key ID", encrypted: tamper(t, sealed, 1), wantErr: ErrUnknownKey},
+		{name: "tampered wrapped key", encrypted: tamper(t, sealed, envelopeHeaderSize)},
+		{name: "tampered ciphertext", encrypted: tamper(t, sealed, envelopeHeaderSize+newKey.Size()+envelopeNonceSize)},
+		{name: "tampered tag", encrypted: tamper(t, sealed, -1)},
+		{name: "truncated", encrypted: sealed[:len(envelopePrefix)+20], wantErr: ErrMalformedEnvelope},
+		{name: "invalid base64", encrypted: envelopePrefix + "not base64!", wantErr: ErrMalformedEnvelope},
+		{name: "unknown key ID", encrypted: unknown, wantErr: ErrUnknownKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := kr.Decrypt(tt.encrypted)
+			if tt.want != "" {
+				if err != nil || got != tt.want {
+					t.Errorf("got %q, %v, want %q", got, err, tt.want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("decrypted %q, want an error", got)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSealRejectsInvalidKeyID(t *testing.T) {
+	if _, err := Seal(Key{ID: "current", Private: newKey}, []byte("{}")); err == nil {
+		t.Error("sealed with a key ID that is not 4 hex bytes")
+	}
+}
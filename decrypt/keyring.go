@@ -0,0 +1,135 @@
+package decrypt
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cpe/calendar/logger"
+)
+
+// keyIDSeparator ends the key ID prefix of encrypted credentials, it is not part of the base64 alphabet
+const keyIDSeparator = "."
+
+var (
+	// ErrUnknownKey is returned for credentials encrypted with a key that is not, or no longer, in the keyring
+	ErrUnknownKey = errors.New("unknown key ID")
+	// ErrNoKey is returned when a keyring is created without keys
+	ErrNoKey = errors.New("no private key configured")
+)
+
+// Key is a private key of the keyring
+type Key struct {
+	// ID identifies the key in encrypted credentials, derived from the public key
+	ID      string
+	Private *rsa.PrivateKey
+}
+
+// PublicKeyPEM returns the PKIX public key in PEM form, as imported by the browser
+func (k Key) PublicKeyPEM() string {
+	der, _ := x509.MarshalPKIXPublicKey(&k.Private.PublicKey)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// Keyring holds the keys able to decrypt credentials.
+// The first key is the current one, handed to browsers for new links;
+// the others are only kept so links created before a rotation keep working.
+type Keyring struct {
+	keys []Key
+	byID map[string]Key
+}
+
+// NewKeyring builds a keyring from private keys, the current one first
+func NewKeyring(privateKeys ...*rsa.PrivateKey) (*Keyring, error) {
+	if len(privateKeys) == 0 {
+		return nil, ErrNoKey
+	}
+
+	kr := &Keyring{byID: map[string]Key{}}
+	for _, privateKey := range privateKeys {
+		key := Key{ID: KeyID(&privateKey.PublicKey), Private: privateKey}
+		if _, ok := kr.byID[key.ID]; ok {
+			return nil, fmt.Errorf("key %s is configured twice", key.ID)
+		}
+		kr.keys = append(kr.keys, key)
+		kr.byID[key.ID] = key
+	}
+	return kr, nil
+}
+
+// LoadKeyring loads the PEM files of the keyring once, the current key first
+func LoadKeyring(pemFiles ...string) (*Keyring, error) {
+	privateKeys := make([]*rsa.PrivateKey, 0, len(pemFiles))
+	for _, pemFile := range pemFiles {
+		privateKey, err := LoadPrivateKey(pemFile)
+		if err != nil {
+			return nil, err
+		}
+		privateKeys = append(privateKeys, privateKey)
+	}
+
+	kr, err := NewKeyring(privateKeys...)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info().
+		Str("currentKeyId", kr.Current().ID).
+		Int("keys", len(kr.keys)).
+		Msg("Keyring loaded")
+	return kr, nil
+}
+
+// KeyID returns the first 8 hex characters of the SHA-256 of the DER public key
+func KeyID(publicKey *rsa.PublicKey) string {
+	der, _ := x509.MarshalPKIXPublicKey(publicKey)
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:4])
+}
+
+// Current returns the key new links are encrypted with
+func (kr *Keyring) Current() Key {
+	return kr.keys[0]
+}
+
+// Decrypt decrypts credentials sealed in an envelope (see Seal), or in the older forms:
+// raw RSA-OAEP "<key ID>.<base64>", or plain base64 tried against every key, the current one first.
+func (kr *Keyring) Decrypt(encrypted string) (string, error) {
+	if encoded, ok := strings.CutPrefix(encrypted, envelopePrefix); ok {
+		data, err := parseEnvelope(encoded)
+		var plaintext []byte
+		if err == nil {
+			plaintext, err = kr.openEnvelope(data)
+		}
+		if err != nil {
+			logger.Log.Error().
+				Str("encrypted", logger.Fingerprint(encrypted)).
+				Err(err).
+				Msg("Failed to open credentials envelope")
+			return "", err
+		}
+		return string(plaintext), nil
+	}
+
+	if id, ciphertext, ok := strings.Cut(encrypted, keyIDSeparator); ok {
+		key, found := kr.byID[id]
+		if !found {
+			logger.Log.Error().
+				Str("keyId", id).
+				Msg("Credentials encrypted with an unknown key")
+			return "", fmt.Errorf("%w %q", ErrUnknownKey, id)
+		}
+		return DecryptMessage(ciphertext, key.Private)
+	}
+
+	privateKeys := make([]*rsa.PrivateKey, len(kr.keys))
+	for i, key := range kr.keys {
+		privateKeys[i] = key.Private
+	}
+	return DecryptMessage(encrypted, privateKeys...)
+}
@@ -0,0 +1,107 @@
+package decrypt
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeKey writes key as a PKCS#1 PEM file in dir
+func writeKey(t *testing.T, dir, name string, key *rsa.PrivateKey) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeyringRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldFile := writeKey(t, dir, "old.pem", oldKey)
+	newFile := writeKey(t, dir, "new.pem", newKey)
+
+	// Links created before the rotation
+	before, err := LoadKeyring(oldFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Seal(before.Current(), []byte("sealed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldID := KeyID(&oldKey.PublicKey)
+	prefixed := oldID + keyIDSeparator + legacy(t, oldKey, "prefixed")
+	unprefixed := legacy(t, oldKey, "unprefixed")
+
+	// The new key is listed first, the old one is kept for those links
+	after, err := LoadKeyring(newFile, oldFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Current().ID != KeyID(&newKey.PublicKey) {
+		t.Fatalf("current key is %s, want the first listed one", after.Current().ID)
+	}
+
+	for encrypted, want := range map[string]string{
+		sealed:     "sealed",
+		prefixed:   "prefixed",
+		unprefixed: "unprefixed",
+		// Unprefixed links are tried against every key, the current one included
+		legacy(t, newKey, "current"): "current",
+	} {
+		got, err := after.Decrypt(encrypted)
+		if err != nil || got != want {
+			t.Errorf("got %q, %v, want %q", got, err, want)
+		}
+	}
+
+	// Once the old key is removed its links stop working
+	removed, err := LoadKeyring(newFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := removed.Decrypt(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("envelope of a removed key: got %v, want %v", err, ErrUnknownKey)
+	}
+	if _, err := removed.Decrypt(prefixed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("prefixed link of a removed key: got %v, want %v", err, ErrUnknownKey)
+	}
+	if _, err := removed.Decrypt(unprefixed); err == nil {
+		t.Error("unprefixed link of a removed key decrypted")
+	}
+}
+
+func TestKeyringPrefixedLinkUsesItsKey(t *testing.T) {
+	kr, err := NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A prefix naming another key than the one used fails instead of trying every key
+	mislabelled := KeyID(&newKey.PublicKey) + keyIDSeparator + legacy(t, oldKey, "secret")
+	if _, err := kr.Decrypt(mislabelled); err == nil {
+		t.Error("link decrypted with a key other than the one it names")
+	}
+	if _, err := kr.Decrypt("0badc0de" + keyIDSeparator + legacy(t, oldKey, "secret")); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestLoadKeyringRejectsDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	first := writeKey(t, dir, "first.pem", newKey)
+	copied := writeKey(t, dir, "copy.pem", newKey)
+
+	if _, err := LoadKeyring(first, copied); err == nil {
+		t.Error("the same key was accepted twice")
+	}
+	if _, err := LoadKeyring(); !errors.Is(err, ErrNoKey) {
+		t.Errorf("got %v, want %v", err, ErrNoKey)
+	}
+}
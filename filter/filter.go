@@ -0,0 +1,174 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"cpe/calendar/lesson"
+)
+
+// maxPatternSize bounds the summary regular expressions accepted from a query
+const maxPatternSize = 200
+
+// ErrInvalidFilter is returned when filter parameters cannot be parsed
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Criteria selects events on one attribute, matching is case-insensitive and ignores surrounding spaces
+type Criteria struct {
+	// Include keeps only the lessons matching one of the values, all lessons when empty
+	Include []string
+	// Exclude drops the lessons matching any of the values
+	Exclude []string
+}
+
+// Filter selects the lessons of a feed, a lesson must pass every criteria
+type Filter struct {
+	// Types matches the activity type exactly, as labelled by mycpe ("Examen", "Cours FHES") or by its kind ("Exam")
+	Types Criteria
+	// Subjects matches part of the subject
+	Subjects Criteria
+	// Teachers matches part of one of the teachers
+	Teachers Criteria
+	// Rooms matches part of one of the rooms
+	Rooms Criteria
+	// Match keeps only the lessons whose title matches, Exclude drops the ones whose title does
+	Match   *regexp.Regexp
+	Exclude *regexp.Regexp
+}
+
+// Parse reads a filter from query parameters. Every parameter may be repeated:
+//
+//	type, subject, teacher, room: keep the matching lessons
+//	not_type, not_subject, not_teacher, not_room: drop the matching lessons
+//	match, not_match: regular expressions on the title
+func Parse(query url.Values) (Filter, error) {
+	f := Filter{
+		Types:    criteria(query, "type"),
+		Subjects: criteria(query, "subject"),
+		Teachers: criteria(query, "teacher"),
+		Rooms:    criteria(query, "room"),
+	}
+
+	var err error
+	if f.Match, err = pattern(query["match"]); err != nil {
+		return Filter{}, err
+	}
+	if f.Exclude, err = pattern(query["not_match"]); err != nil {
+		return Filter{}, err
+	}
+	return f, nil
+}
+
+// Empty reports whether the filter keeps every lesson
+func (f Filter) Empty() bool {
+	return f.Types.empty() && f.Subjects.empty() && f.Teachers.empty() && f.Rooms.empty() &&
+		f.Match == nil && f.Exclude == nil
+}
+
+// Apply returns the lessons kept by the filter
+func (f Filter) Apply(lessons []lesson.Lesson) []lesson.Lesson {
+	if f.Empty() {
+		return lessons
+	}
+
+	kept := make([]lesson.Lesson, 0, len(lessons))
+	for _, l := range lessons {
+		if f.Keep(l) {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+// Keep reports whether a lesson passes the filter
+func (f Filter) Keep(l lesson.Lesson) bool {
+	if !f.Types.keep([]string{l.Label, string(l.Kind)}, equal) ||
+		!f.Subjects.keep([]string{l.Subject}, contains) ||
+		!f.Teachers.keep(l.Teachers, contains) ||
+		!f.Rooms.keep(l.Rooms, contains) {
+		return false
+	}
+
+	title := l.Title()
+	if f.Match != nil && !f.Match.MatchString(title) {
+		return false
+	}
+	if f.Exclude != nil && f.Exclude.MatchString(title) {
+		return false
+	}
+	return true
+}
+
+// criteria reads the include and exclude values of a parameter, ignoring blank ones
+func criteria(query url.Values, name string) Criteria {
+	return Criteria{
+		Include: values(query[name]),
+		Exclude: values(query["not_"+name]),
+	}
+}
+
+func values(raw []string) []string {
+	var values []string
+	for _, value := range raw {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// pattern compiles the case-insensitive alternation of the given regular expressions
+func pattern(raw []string) (*regexp.Regexp, error) {
+	exprs := values(raw)
+	if len(exprs) == 0 {
+		return nil, nil
+	}
+
+	for i, expr := range exprs {
+		if len(expr) > maxPatternSize {
+			return nil, fmt.Errorf("%w: pattern longer than %d characters", ErrInvalidFilter, maxPatternSize)
+		}
+		if _, err := regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+		}
+		exprs[i] = "(?:" + expr + ")"
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(exprs, "|")), nil
+}
+
+func (c Criteria) empty() bool {
+	return len(c.Include) == 0 && len(c.Exclude) == 0
+}
+
+// keep reports whether one of attrs matches an included value, when there are any, and none matches an excluded one
+func (c Criteria) keep(attrs []string, match func(attr, value string) bool) bool {
+	if len(c.Include) > 0 && !matchAny(attrs, c.Include, match) {
+		return false
+	}
+	return !matchAny(attrs, c.Exclude, match)
+}
+
+func matchAny(attrs, values []string, match func(attr, value string) bool) bool {
+	for _, attr := range attrs {
+		if attr == "" {
+			continue
+		}
+		for _, value := range values {
+			if match(attr, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func equal(attr, value string) bool {
+	return strings.EqualFold(attr, value)
+}
+
+func contains(attr, value string) bool {
+	return strings.Contains(strings.ToLower(attr), strings.ToLower(value))
+}
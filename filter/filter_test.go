@@ -0,0 +1,150 @@
+package filter
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"cpe/calendar/lesson"
+)
+
+// lessons is a week of mixed activities
+var lessons = []lesson.Lesson{
+	{Kind: lesson.KindLecture, Label: "CM", Subject: "Réseaux", Teachers: []string{"MARTIN"}, Rooms: []string{"E200"}},
+	{Kind: lesson.KindTutorial, Label: "TD", Subject: "Réseaux", Teachers: []string{"DUPONT", "MARTIN"}, Rooms: []string{"E201"}},
+	{Kind: lesson.KindExam, Label: "Examen", Subject: "Mathématiques", Teachers: []string{"LEROY"}, Rooms: []string{"A1-Amphi Hubert Curien"}},
+	{Kind: lesson.KindOther, Label: "Cours FHES", Subject: "Anglais", Teachers: []string{"SMITH"}, Rooms: []string{"C101"}},
+	{Kind: lesson.KindPractical, Label: "TP", Subject: "Chimie", Rooms: []string{"C102"}},
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{
+			name:  "no filter",
+			query: "",
+			want:  []string{"CM Réseaux", "TD Réseaux", "Examen Mathématiques", "Cours FHES Anglais", "TP Chimie"},
+		},
+		{
+			name:  "blank values are ignored",
+			query: "type=&subject=+",
+			want:  []string{"CM Réseaux", "TD Réseaux", "Examen Mathématiques", "Cours FHES Anglais", "TP Chimie"},
+		},
+		{
+			name:  "repeated type",
+			query: "type=CM&type=TD",
+			want:  []string{"CM Réseaux", "TD Réseaux"},
+		},
+		{
+			name:  "type by mycpe label",
+			query: "type=examen",
+			want:  []string{"Examen Mathématiques"},
+		},
+		{
+			name:  "type by kind",
+			query: "type=exam",
+			want:  []string{"Examen Mathématiques"},
+		},
+		{
+			// Types match exactly, "Cours" is not "Cours FHES"
+			name:  "type is not a substring",
+			query: "type=Cours",
+			want:  nil,
+		},
+		{
+			name:  "excluded type with spaces",
+			query: "not_type=" + url.QueryEscape(" cours fhes ") + "&not_type=other",
+			want:  []string{"CM Réseaux", "TD Réseaux", "Examen Mathématiques", "TP Chimie"},
+		},
+		{
+			name:  "case-insensitive subject",
+			query: "subject=RÉSEAUX",
+			want:  []string{"CM Réseaux", "TD Réseaux"},
+		},
+		{
+			name:  "include and exclude combined",
+			query: "subject=réseaux&not_type=CM",
+			want:  []string{"TD Réseaux"},
+		},
+		{
+			name:  "one of the teachers",
+			query: "teacher=martin",
+			want:  []string{"CM Réseaux", "TD Réseaux"},
+		},
+		{
+			name:  "excluded teacher",
+			query: "not_teacher=dupont&not_teacher=smith",
+			want:  []string{"CM Réseaux", "Examen Mathématiques", "TP Chimie"},
+		},
+		{
+			name:  "part of a room",
+			query: "room=amphi&room=c10",
+			want:  []string{"Examen Mathématiques", "Cours FHES Anglais", "TP Chimie"},
+		},
+		{
+			name:  "excluded room",
+			query: "not_room=E20",
+			want:  []string{"Examen Mathématiques", "Cours FHES Anglais", "TP Chimie"},
+		},
+		{
+			name:  "every criteria must pass",
+			query: "room=c10&teacher=smith",
+			want:  []string{"Cours FHES Anglais"},
+		},
+		{
+			name:  "repeated match",
+			query: "match=" + url.QueryEscape("^cm ") + "&match=chimie",
+			want:  []string{"CM Réseaux", "TP Chimie"},
+		},
+		{
+			name:  "match and not_match combined",
+			query: "match=r.seaux&not_match=" + url.QueryEscape("^TD"),
+			want:  []string{"CM Réseaux"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := Parse(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, l := range f.Apply(lessons) {
+				got = append(got, l.Title())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{name: "invalid match", query: url.Values{"match": {"CM", "(TD"}}},
+		{name: "invalid not_match", query: url.Values{"not_match": {"[a-"}}},
+		{name: "pattern too long", query: url.Values{"match": {strings.Repeat("a", maxPatternSize+1)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.query); !errors.Is(err, ErrInvalidFilter) {
+				t.Errorf("got %v, want %v", err, ErrInvalidFilter)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notModified evaluates If-None-Match and If-Modified-Since (RFC 9110 §13.2.2) against the feed validators.
+// If-Modified-Since is only considered when the client did not send If-None-Match.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || weakETag(candidate) == weakETag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// weakETag strips the weak indicator so entity tags can be compared with the weak comparison function
+func weakETag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	const etag = `W/"abc"`
+	lastModified := time.Date(2025, 2, 17, 12, 0, 0, 500, time.UTC)
+	before := lastModified.Add(-time.Hour).Format(http.TimeFormat)
+	at := lastModified.Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{name: "no validators", want: false},
+		{name: "matching etag", header: http.Header{"If-None-Match": {`W/"abc"`}}, want: true},
+		{name: "strong form of the etag", header: http.Header{"If-None-Match": {`"abc"`}}, want: true},
+		{name: "one of several etags", header: http.Header{"If-None-Match": {`"old", W/"abc"`}}, want: true},
+		{name: "other etag", header: http.Header{"If-None-Match": {`W/"old"`}}, want: false},
+		{name: "any etag", header: http.Header{"If-None-Match": {"*"}}, want: true},
+		{name: "modified since", header: http.Header{"If-Modified-Since": {before}}, want: false},
+		{name: "not modified since", header: http.Header{"If-Modified-Since": {at}}, want: true},
+		{name: "invalid date", header: http.Header{"If-Modified-Since": {"yesterday"}}, want: false},
+		{
+			name:   "If-None-Match takes precedence over a matching If-Modified-Since",
+			header: http.Header{"If-None-Match": {`W/"old"`}, "If-Modified-Since": {at}},
+			want:   false,
+		},
+		{
+			name:   "If-None-Match takes precedence over a stale If-Modified-Since",
+			header: http.Header{"If-None-Match": {etag}, "If-Modified-Since": {before}},
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header = tt.header
+			if r.Header == nil {
+				r.Header = http.Header{}
+			}
+			if got := notModified(r, etag, lastModified); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateICSHandlerConditional(t *testing.T) {
+	env := newTestEnv(t, nil)
+	target := "/your-cpe-calendar.ics?creds=" + url.QueryEscape(env.seal(t, "student@cpe.fr", "password"))
+
+	first := get(env.h.GenerateICSHandler, target, nil)
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if first.Code != http.StatusOK || etag == "" || lastModified == "" {
+		t.Fatalf("status %d with ETag %q and Last-Modified %q", first.Code, etag, lastModified)
+	}
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		wantStatus int
+	}{
+		{name: "matching etag", header: http.Header{"If-None-Match": {etag}}, wantStatus: http.StatusNotModified},
+		{name: "other etag", header: http.Header{"If-None-Match": {`W/"old"`}}, wantStatus: http.StatusOK},
+		{name: "any etag", header: http.Header{"If-None-Match": {"*"}}, wantStatus: http.StatusNotModified},
+		{name: "not modified since", header: http.Header{"If-Modified-Since": {lastModified}}, wantStatus: http.StatusNotModified},
+		{
+			name:       "etag takes precedence",
+			header:     http.Header{"If-None-Match": {`W/"old"`}, "If-Modified-Since": {lastModified}},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(env.h.GenerateICSHandler, target, tt.header)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Header().Get("ETag") != etag {
+				t.Errorf("ETag %q, want %q", w.Header().Get("ETag"), etag)
+			}
+			if tt.wantStatus == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 with a body: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestGenerateICSHandlerHead(t *testing.T) {
+	env := newTestEnv(t, nil)
+	target := "/your-cpe-calendar.ics?creds=" + url.QueryEscape(env.seal(t, "student@cpe.fr", "password"))
+	full := get(env.h.GenerateICSHandler, target, nil)
+
+	r := httptest.NewRequest(http.MethodHead, target, nil)
+	w := httptest.NewRecorder()
+	env.h.GenerateICSHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("HEAD returned a %d bytes body", w.Body.Len())
+	}
+	for _, name := range []string{"Content-Type", "Content-Disposition", "ETag", "Last-Modified", "Cache-Control"} {
+		if got, want := w.Header().Get(name), full.Header().Get(name); got == "" || got != want {
+			t.Errorf("%s: got %q, want %q as for GET", name, got, want)
+		}
+	}
+}
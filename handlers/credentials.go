@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"cpe/calendar/decrypt"
+	"cpe/calendar/logger"
+	"net/http"
+)
+
+// decodeCredentials decrypts cryptedCreds, the 'creds' value of a link or a form, into its credentials
+func (h *Handlers) decodeCredentials(r *http.Request, cryptedCreds string) (decrypt.Credentials, *apiError) {
+	log := logger.Ctx(r.Context())
+
+	if cryptedCreds == "" {
+		log.Error().
+			Msg("Missing credentials")
+		return decrypt.Credentials{}, errBadCiphertext
+	}
+
+	// Decrypt the message with the key it names, or any key for legacy links
+	decryptedMessage, err := h.keyring.Decrypt(cryptedCreds)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("creds", logger.Fingerprint(cryptedCreds)).
+			Msg("Error decrypting message")
+		return decrypt.Credentials{}, errBadCiphertext
+	}
+
+	// Parse the JSON payload, or the separator based one of legacy links
+	creds, err := decrypt.ParseCredentials(decryptedMessage, h.cfg.Credentials.Separator)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Invalid credentials format")
+		return decrypt.Credentials{}, errBadFormat
+	}
+
+	// Log successful decryption of message
+	log.Info().
+		Str("user", logger.HashUser(creds.Username)).
+		Int("version", creds.Version).
+		Time("createdAt", creds.CreatedAt).
+		Msg("Credentials decrypted successfully")
+
+	return creds, nil
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cpe/calendar/logger"
+	"cpe/calendar/request"
+)
+
+// apiError is a failure reported to the client with a status code and a stable error code
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	// RetryAfter is sent as the Retry-After header when set, in seconds
+	RetryAfter int
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errBadCiphertext       = &apiError{Status: http.StatusBadRequest, Code: "bad_ciphertext", Message: "Invalid credentials"}
+	errBadFormat           = &apiError{Status: http.StatusBadRequest, Code: "bad_format", Message: "Invalid credentials format"}
+	errInvalidRange        = &apiError{Status: http.StatusBadRequest, Code: "invalid_range", Message: "Invalid date range"}
+	errInvalidFilter       = &apiError{Status: http.StatusBadRequest, Code: "invalid_filter", Message: "Invalid filter"}
+	errInvalidCredentials  = &apiError{Status: http.StatusUnauthorized, Code: "invalid_credentials", Message: "CPE credentials were rejected"}
+	errStaleCredentials    = &apiError{Status: http.StatusUnauthorized, Code: "stale_credentials", Message: "Credentials must be freshly encrypted"}
+	errRateLimited         = &apiError{Status: http.StatusTooManyRequests, Code: "rate_limited", Message: "mycpe is rate limiting requests, try again later", RetryAfter: 300}
+	errUpstreamUnavailable = &apiError{Status: http.StatusBadGateway, Code: "upstream_unavailable", Message: "mycpe is unavailable"}
+	errUpstreamCircuitOpen = &apiError{Status: http.StatusServiceUnavailable, Code: "upstream_unavailable", Message: "mycpe is unavailable", RetryAfter: 60}
+	errUpstreamSchema      = &apiError{Status: http.StatusBadGateway, Code: "upstream_schema_changed", Message: "mycpe answered with an unexpected format"}
+	errUpstreamTimeout     = &apiError{Status: http.StatusGatewayTimeout, Code: "upstream_timeout", Message: "mycpe did not answer in time"}
+	errUnknownFeed         = &apiError{Status: http.StatusNotFound, Code: "unknown_feed", Message: "This calendar link was revoked or does not exist"}
+	errInternal            = &apiError{Status: http.StatusInternalServerError, Code: "internal", Message: "Internal error"}
+)
+
+// upstreamError maps a failure of the request package to the error reported to the client
+func upstreamError(err error) *apiError {
+	if request.IsTimeout(err) {
+		return errUpstreamTimeout
+	}
+
+	switch request.Kind(err) {
+	case request.KindUnauthorized:
+		return errInvalidCredentials
+	case request.KindRateLimited:
+		return rateLimitedError(err)
+	case request.KindMalformed:
+		return errUpstreamSchema
+	case request.KindCircuitOpen:
+		return errUpstreamCircuitOpen
+	case request.KindNetwork, request.KindServer, request.KindClient:
+		return errUpstreamUnavailable
+	default:
+		return errInternal
+	}
+}
+
+// rateLimitedError reports a 429 with the Retry-After announced by mycpe, when there is one
+func rateLimitedError(err error) *apiError {
+	retryAfter := request.RetryAfter(err)
+	if retryAfter <= 0 {
+		return errRateLimited
+	}
+	apiErr := *errRateLimited
+	apiErr.RetryAfter = int(math.Ceil(retryAfter.Seconds()))
+	return &apiErr
+}
+
+// writeError answers with a JSON body to API clients and plain text to everything else
+func writeError(w http.ResponseWriter, r *http.Request, apiErr *apiError) {
+	if wantsJSON(r) {
+		writeJSONError(w, apiErr)
+		return
+	}
+
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(apiErr.RetryAfter))
+	}
+	http.Error(w, apiErr.Message, apiErr.Status)
+}
+
+// writeJSONError answers with {"error": code, "message": message}
+func writeJSONError(w http.ResponseWriter, apiErr *apiError) {
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(apiErr.RetryAfter))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+
+	err := json.NewEncoder(w).Encode(struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   apiErr.Code,
+		Message: apiErr.Message,
+	})
+	if err != nil {
+		logger.Log.Error().
+			Err(err).
+			Msg("Error writing JSON error response")
+	}
+}
+
+// wantsJSON reports whether the client asked for a JSON answer
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"cpe/calendar/logger"
+	"cpe/calendar/vault"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// FeedHandler serves the calendar of a /cal/{token}.ics link
+func (h *Handlers) FeedHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	creds, err := h.vault.Get(token)
+	if err != nil {
+		h.vaultError(w, r, token, err)
+		return
+	}
+
+	// Remember the client so the owner can recognise their devices, a failure only loses that
+	if err := h.vault.Touch(token, time.Now(), r.UserAgent()); err != nil {
+		logger.Ctx(r.Context()).Error().
+			Err(err).
+			Str("feed", feedID(token)).
+			Msg("Failed to record feed access")
+	}
+
+	h.serveCalendar(w, r, creds)
+}
+
+// CreateFeedHandler stores the credentials of the 'creds' form value once mycpe accepted them,
+// and answers with the /cal/{token}.ics link replacing a link carrying them
+func (h *Handlers) CreateFeedHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.Ctx(r.Context())
+
+	// Only fresh credentials mycpe accepts are worth a link
+	creds, apiErr := h.authenticate(r)
+	if apiErr != nil {
+		writeJSONError(w, apiErr)
+		return
+	}
+
+	token, err := h.vault.Put(h.subscriberKey(creds.Username), creds, time.Now())
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Failed to store credentials in the vault")
+		writeJSONError(w, errInternal)
+		return
+	}
+
+	log.Info().
+		Str("user", logger.HashUser(creds.Username)).
+		Str("feed", feedID(token)).
+		Msg("Feed created")
+	writeFeed(w, r, http.StatusCreated, token)
+}
+
+// RevokeFeedHandler deletes a /cal/{token}.ics link of the account proven by the 'creds' form value.
+// Holding the link is not enough: it is shared with calendar apps and may leak with them.
+func (h *Handlers) RevokeFeedHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	creds, apiErr := h.authenticate(r)
+	if apiErr != nil {
+		writeJSONError(w, apiErr)
+		return
+	}
+
+	if err := h.vault.RevokeID(h.subscriberKey(creds.Username), vault.TokenID(token)); err != nil {
+		h.subscriptionError(w, r, vault.TokenID(token), err)
+		return
+	}
+
+	logger.Ctx(r.Context()).Info().
+		Str("feed", feedID(token)).
+		Msg("Feed revoked by its owner")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// vaultError reports a vault failure, unknown tokens are not worth an error log
+func (h *Handlers) vaultError(w http.ResponseWriter, r *http.Request, token string, err error) {
+	if errors.Is(err, vault.ErrNotFound) {
+		logger.Ctx(r.Context()).Info().
+			Str("feed", feedID(token)).
+			Msg("Unknown feed requested")
+		writeError(w, r, errUnknownFeed)
+		return
+	}
+
+	logger.Ctx(r.Context()).Error().
+		Err(err).
+		Str("feed", feedID(token)).
+		Msg("Failed to read the vault")
+	writeError(w, r, errInternal)
+}
+
+// writeFeed answers with the token of a feed and its calendar URL
+func writeFeed(w http.ResponseWriter, r *http.Request, status int, token string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(struct {
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}{
+		Token: token,
+		URL:   "/cal/" + token + ".ics",
+	})
+	if err != nil {
+		logger.Ctx(r.Context()).Error().
+			Err(err).
+			Msg("Error writing feed response")
+	}
+}
+
+// feedID is the loggable form of a token
+func feedID(token string) string {
+	return vault.TokenID(token)[:12]
+}
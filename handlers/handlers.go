@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"cpe/calendar/cache"
+	"cpe/calendar/config"
+	"cpe/calendar/decrypt"
+	"cpe/calendar/history"
+	"cpe/calendar/ical"
+	"cpe/calendar/logger"
+	"cpe/calendar/request"
+	"cpe/calendar/vault"
+	"cpe/calendar/window"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+)
+
+// Handlers serves the application endpoints with the dependencies built from the configuration
+type Handlers struct {
+	cfg     config.Config
+	client  *request.Client
+	policy  window.Policy
+	cache   *cache.Cache
+	history *history.Store
+	keyring *decrypt.Keyring
+	vault   *vault.Vault
+	index   *template.Template
+}
+
+// New builds the handlers and their dependencies from a validated configuration
+func New(cfg config.Config) (*Handlers, error) {
+	index, err := template.ParseFiles(filepath.Join(cfg.Server.StaticDir, "index.html"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse index template: %w", err)
+	}
+
+	// Load the keys once, a bad key stops the startup instead of failing every request
+	keyring, err := decrypt.LoadKeyring(cfg.Credentials.PrivateKeys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keyring: %w", err)
+	}
+
+	h := &Handlers{
+		cfg:     cfg,
+		client:  request.NewClient(cfg.UpstreamConfig()),
+		policy:  cfg.WindowPolicy(),
+		cache:   cache.New(cfg.Cache.TTL, cfg.Cache.MaxStale),
+		keyring: keyring,
+		index:   index,
+	}
+
+	// Track schedule changes only when enabled, the store needs a writable directory
+	if cfg.Features.History {
+		store, err := history.NewStore(cfg.History.Dir, cfg.History.Grace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open history store: %w", err)
+		}
+		h.history = store
+	}
+
+	// Store credentials server side only when enabled, the vault needs a key and a writable directory
+	if cfg.Features.Vault {
+		v, err := vault.Open(cfg.Vault.Dir, cfg.VaultKey())
+		if err != nil {
+			return nil, fmt.Errorf("failed to open vault: %w", err)
+		}
+		h.vault = v
+	}
+
+	logger.Log.Info().
+		Str("timezone", cfg.Calendar.Location.String()).
+		Bool("localTime", cfg.Calendar.LocalTime).
+		Str("windowMode", h.policy.Mode).
+		Int("daysBack", h.policy.DaysBack).
+		Int("daysForward", h.policy.DaysForward).
+		Dur("cacheTTL", cfg.Cache.TTL).
+		Dur("cacheMaxStale", cfg.Cache.MaxStale).
+		Bool("history", h.history != nil).
+		Bool("vault", h.vault != nil).
+		Msg("Handlers configured")
+
+	return h, nil
+}
+
+// calendarOptions returns the serialization options of a feed answering r
+func (h *Handlers) calendarOptions(r *http.Request) ical.Options {
+	return ical.Options{
+		Location:  h.cfg.Calendar.Location,
+		LocalTime: h.cfg.Calendar.LocalTime,
+		UIDDomain: h.cfg.Calendar.UIDDomain,
+		MultiDay:  h.cfg.Calendar.MultiDay,
+		EmptyDays: h.cfg.Calendar.EmptyDays,
+		Breaks:    h.cfg.Calendar.Breaks,
+		Log:       logger.Ctx(r.Context()),
+	}
+}
+
+// subscriberKey returns the history and vault key of username
+func (h *Handlers) subscriberKey(username string) string {
+	return history.SubscriberKey(h.cfg.History.SubscriberSalt, username)
+}
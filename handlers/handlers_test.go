@@ -0,0 +1,558 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cpe/calendar/config"
+	"cpe/calendar/decrypt"
+	"cpe/calendar/logger"
+	"cpe/calendar/mockcpe"
+	"cpe/calendar/vault"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+// testKey is shared by the tests, RSA key generation is slow
+var testKey *rsa.PrivateKey
+
+func TestMain(m *testing.M) {
+	var err error
+	testKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// testEnv is a Handlers instance talking to a mock mycpe
+type testEnv struct {
+	h    *Handlers
+	mock *mockcpe.Server
+}
+
+// newTestEnv builds handlers against a mock mycpe, mutate adjusts the configuration before validation
+func newTestEnv(t *testing.T, mutate func(*config.Config)) *testEnv {
+	t.Helper()
+
+	mock, err := mockcpe.NewServer(mockcpe.Fixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(mock)
+	t.Cleanup(upstream.Close)
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "private.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testKey)})
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Server.StaticDir = "../static"
+	cfg.Credentials.PrivateKeys = []string{keyFile}
+	cfg.Upstream.BaseURL = upstream.URL
+	cfg.History.Dir = filepath.Join(dir, "history")
+	cfg.Vault.Dir = filepath.Join(dir, "vault")
+	if mutate != nil {
+		mutate(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{h: h, mock: mock}
+}
+
+// seal encrypts credentials the way static/index.html does
+func (e *testEnv) seal(t *testing.T, username, password string) string {
+	t.Helper()
+	return e.sealAt(t, username, password, time.Now())
+}
+
+// sealAt encrypts credentials as if the browser had done it at createdAt
+func (e *testEnv) sealAt(t *testing.T, username, password string, createdAt time.Time) string {
+	t.Helper()
+	payload, err := json.Marshal(decrypt.Credentials{
+		Version:   decrypt.CredentialsVersion,
+		Username:  username,
+		Password:  password,
+		CreatedAt: createdAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e.sealPayload(t, payload)
+}
+
+// sealPayload encrypts a raw payload with the current key
+func (e *testEnv) sealPayload(t *testing.T, payload []byte) string {
+	t.Helper()
+	sealed, err := decrypt.Seal(e.h.keyring.Current(), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+// get runs handler on a GET request of target with the given headers
+func get(handler http.HandlerFunc, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func jsonAccept() http.Header {
+	return http.Header{"Accept": {"application/json"}}
+}
+
+func TestGenerateICSHandler(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	tests := []struct {
+		name       string
+		creds      string
+		header     http.Header
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "valid credentials",
+			creds:      env.seal(t, "student@cpe.fr", "password"),
+			wantStatus: http.StatusOK,
+			wantBody:   "SUMMARY:TD Architecture et Langages du Web",
+		},
+		{
+			name:       "empty timetable",
+			creds:      env.seal(t, "empty@cpe.fr", "password"),
+			wantStatus: http.StatusOK,
+			wantBody:   "END:VCALENDAR",
+		},
+		{
+			name:       "wrong password from an API client",
+			creds:      env.seal(t, "student@cpe.fr", "wrong"),
+			header:     jsonAccept(),
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   `"error":"invalid_credentials"`,
+		},
+		{
+			name:       "wrong password from a calendar client",
+			creds:      env.seal(t, "student@cpe.fr", "wrong"),
+			wantStatus: http.StatusOK,
+			wantBody:   "please re-enter your password",
+		},
+		{
+			name:       "malformed upstream payload",
+			creds:      env.seal(t, "schema@cpe.fr", "password"),
+			header:     jsonAccept(),
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `"error":"upstream_schema_changed"`,
+		},
+		{
+			name:       "undecryptable credentials",
+			creds:      "not-a-ciphertext",
+			header:     jsonAccept(),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"error":"bad_ciphertext"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(env.h.GenerateICSHandler, "/your-cpe-calendar.ics?creds="+url.QueryEscape(tt.creds), tt.header)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body does not contain %q:\n%s", tt.wantBody, w.Body)
+			}
+		})
+	}
+}
+
+func TestGenerateICSHandlerDefaultWindow(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	// The mock moves its fixtures to the current weeks, the default window must see them
+	w := get(env.h.GenerateICSHandler, "/your-cpe-calendar.ics?creds="+url.QueryEscape(env.seal(t, "student@cpe.fr", "password")), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if n := strings.Count(w.Body.String(), "BEGIN:VEVENT"); n != 5 {
+		t.Errorf("%d events, want the 5 lessons of the fixture", n)
+	}
+}
+
+func TestGenerateICSHandlerFilters(t *testing.T) {
+	env := newTestEnv(t, nil)
+	target := "/your-cpe-calendar.ics?creds=" + url.QueryEscape(env.seal(t, "student@cpe.fr", "password"))
+
+	w := get(env.h.GenerateICSHandler, target+"&type=examen&type=CM&not_type=Exam", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if n := strings.Count(w.Body.String(), "BEGIN:VEVENT"); n != 1 {
+		t.Errorf("%d events, want only the lecture", n)
+	}
+
+	// An invalid expression is refused before asking mycpe anything
+	w = get(env.h.GenerateICSHandler, target+"&match="+url.QueryEscape("(CM"), jsonAccept())
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"error":"invalid_filter"`) {
+		t.Errorf("invalid match: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestGenerateICSHandlerRevokedToken(t *testing.T) {
+	env := newTestEnv(t, func(cfg *config.Config) {
+		// Fetch again on every request to reuse the cached mycpe token
+		cfg.Cache.TTL = time.Nanosecond
+		cfg.Cache.MaxStale = time.Nanosecond
+	})
+	target := "/your-cpe-calendar.ics?creds=" + url.QueryEscape(env.seal(t, "student@cpe.fr", "password"))
+
+	if w := get(env.h.GenerateICSHandler, target, nil); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d: %s", w.Code, w.Body)
+	}
+
+	// mycpe invalidates the cached token, the client must log in again
+	env.mock.Revoke()
+	time.Sleep(time.Millisecond)
+	w := get(env.h.GenerateICSHandler, target, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "BEGIN:VEVENT") {
+		t.Fatalf("after revocation: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestValidateHandler(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	tests := []struct {
+		name       string
+		creds      string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "valid credentials", creds: env.seal(t, "student@cpe.fr", "password"), wantStatus: http.StatusOK},
+		{name: "wrong password", creds: env.seal(t, "student@cpe.fr", "wrong"), wantStatus: http.StatusUnauthorized, wantError: "invalid_credentials"},
+		{name: "unknown account", creds: env.seal(t, "nobody@cpe.fr", "password"), wantStatus: http.StatusUnauthorized, wantError: "invalid_credentials"},
+		{name: "missing credentials", creds: "", wantStatus: http.StatusBadRequest, wantError: "bad_ciphertext"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(env.h.ValidateHandler, "/validate?creds="+url.QueryEscape(tt.creds), jsonAccept())
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantError == "" {
+				return
+			}
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error != tt.wantError {
+				t.Errorf("error %q, want %q", body.Error, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestHandlersDoNotLogSecrets(t *testing.T) {
+	env := newTestEnv(t, nil)
+
+	// Capture every level without the redacting writer, the handlers must not rely on it
+	var logs bytes.Buffer
+	saved := logger.Log
+	logger.Log = zerolog.New(zerolog.SyncWriter(&logs)).Level(zerolog.TraceLevel)
+	t.Cleanup(func() { logger.Log = saved })
+
+	const username, password = "student@cpe.fr", "password"
+	good := env.seal(t, username, password)
+	bad := env.seal(t, username, "wrong-password")
+	schema := env.seal(t, "schema@cpe.fr", password)
+
+	routes := logger.Middleware
+	for _, run := range []struct {
+		handler http.HandlerFunc
+		target  string
+	}{
+		{env.h.GenerateICSHandler, "/your-cpe-calendar.ics?creds=" + url.QueryEscape(good)},
+		{env.h.GenerateICSHandler, "/your-cpe-calendar.ics?creds=" + url.QueryEscape(bad)},
+		{env.h.GenerateICSHandler, "/your-cpe-calendar.ics?creds=" + url.QueryEscape(schema)},
+		{env.h.GenerateICSHandler, "/your-cpe-calendar.ics?creds=" + url.QueryEscape(good[:len(good)-8])},
+		{env.h.ValidateHandler, "/validate?creds=" + url.QueryEscape(good)},
+		{env.h.ValidateHandler, "/validate?creds=" + url.QueryEscape(bad)},
+	} {
+		w := httptest.NewRecorder()
+		routes(run.handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, run.target, nil))
+	}
+
+	if logs.Len() == 0 {
+		t.Fatal("nothing was logged, the capture does not work")
+	}
+	for name, secret := range map[string]string{
+		"username":           username,
+		"password":           "wrong-password",
+		"ciphertext":         good,
+		"ciphertext start":   good[:32],
+		"escaped ciphertext": url.QueryEscape(good)[:32],
+		// Every JWT issued by the mock starts with its encoded {" header
+		"mycpe token": "eyJ",
+	} {
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("the %s appears in the logs", name)
+		}
+	}
+}
+
+// withVault enables token links
+func withVault(cfg *config.Config) {
+	cfg.Features.Vault = true
+	cfg.Vault.Key = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, vault.KeySize))
+}
+
+// post runs handler on a POST request of target with form as its body
+func post(handler http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestValidateHandlerStoresNothing(t *testing.T) {
+	env := newTestEnv(t, withVault)
+
+	w := get(env.h.ValidateHandler, "/validate?creds="+url.QueryEscape(env.seal(t, "student@cpe.fr", "password")), jsonAccept())
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	entries, err := env.h.vault.List(env.h.subscriberKey("student@cpe.fr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("GET /validate stored %d feeds", len(entries))
+	}
+}
+
+func TestCreateFeedHandler(t *testing.T) {
+	env := newTestEnv(t, withVault)
+
+	w := post(env.h.CreateFeedHandler, "/cal", url.Values{"creds": {env.seal(t, "student@cpe.fr", "wrong")}})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status %d: %s", w.Code, w.Body)
+	}
+
+	feed := env.createFeed(t, "student@cpe.fr")
+	if feed.URL != "/cal/"+feed.Token+".ics" {
+		t.Errorf("url %q does not match token %q", feed.URL, feed.Token)
+	}
+
+	// The link serves the calendar of the stored credentials
+	if cal := env.getFeed(feed.Token); cal.Code != http.StatusOK || !strings.Contains(cal.Body.String(), "BEGIN:VEVENT") {
+		t.Errorf("feed: status %d: %s", cal.Code, cal.Body)
+	}
+}
+
+// feed is the answer of POST /cal
+type feed struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// createFeed stores the credentials of username, whose password is "password", behind a new token
+func (e *testEnv) createFeed(t *testing.T, username string) feed {
+	t.Helper()
+	w := post(e.h.CreateFeedHandler, "/cal", url.Values{"creds": {e.seal(t, username, "password")}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create feed: status %d: %s", w.Code, w.Body)
+	}
+	var f feed
+	if err := json.NewDecoder(w.Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// getFeed requests the calendar of a token link
+func (e *testEnv) getFeed(token string) *httptest.ResponseRecorder {
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/cal/"+token+".ics", nil), map[string]string{"token": token})
+	w := httptest.NewRecorder()
+	e.h.FeedHandler(w, r)
+	return w
+}
+
+// revokeFeed posts form to the revocation of a token link
+func (e *testEnv) revokeFeed(token string, form url.Values) *httptest.ResponseRecorder {
+	target := "/cal/" + token + "/revoke"
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode())), map[string]string{"token": token})
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	e.h.RevokeFeedHandler(w, r)
+	return w
+}
+
+func TestRevokeFeedHandlerRequiresTheOwner(t *testing.T) {
+	env := newTestEnv(t, withVault)
+	f := env.createFeed(t, "student@cpe.fr")
+
+	// Whoever saw the link cannot revoke it
+	if w := env.revokeFeed(f.Token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("without credentials: status %d: %s", w.Code, w.Body)
+	}
+	// Nor can another student
+	if w := env.revokeFeed(f.Token, url.Values{"creds": {env.seal(t, "empty@cpe.fr", "password")}}); w.Code != http.StatusNotFound {
+		t.Errorf("another account: status %d: %s", w.Code, w.Body)
+	}
+	if w := env.getFeed(f.Token); w.Code != http.StatusOK {
+		t.Fatalf("feed revoked by someone else: status %d", w.Code)
+	}
+
+	if w := env.revokeFeed(f.Token, url.Values{"creds": {env.seal(t, "student@cpe.fr", "password")}}); w.Code != http.StatusNoContent {
+		t.Fatalf("owner: status %d: %s", w.Code, w.Body)
+	}
+	if w := env.getFeed(f.Token); w.Code != http.StatusNotFound {
+		t.Errorf("revoked feed: status %d", w.Code)
+	}
+}
+
+func TestAuthenticateRequiresFreshCredentials(t *testing.T) {
+	env := newTestEnv(t, withVault)
+	const username, password = "student@cpe.fr", "password"
+	legacy := []byte(username + env.h.cfg.Credentials.Separator + password)
+
+	tests := []struct {
+		name       string
+		creds      string
+		inQuery    bool
+		wantStatus int
+		wantError  string
+	}{
+		{name: "fresh credentials", creds: env.seal(t, username, password), wantStatus: http.StatusOK},
+		{name: "encrypted a few minutes ago", creds: env.sealAt(t, username, password, time.Now().Add(-4*time.Minute)), wantStatus: http.StatusOK},
+		{name: "old link", creds: env.sealAt(t, username, password, time.Now().Add(-time.Hour)), wantStatus: http.StatusUnauthorized, wantError: "stale_credentials"},
+		{name: "dated in the future", creds: env.sealAt(t, username, password, time.Now().Add(time.Hour)), wantStatus: http.StatusUnauthorized, wantError: "stale_credentials"},
+		{name: "no creation date", creds: env.sealAt(t, username, password, time.Time{}), wantStatus: http.StatusUnauthorized, wantError: "stale_credentials"},
+		{name: "legacy payload", creds: env.sealPayload(t, legacy), wantStatus: http.StatusUnauthorized, wantError: "stale_credentials"},
+		{name: "credentials in the query string", creds: env.seal(t, username, password), inQuery: true, wantStatus: http.StatusBadRequest, wantError: "bad_ciphertext"},
+		{name: "wrong password", creds: env.seal(t, username, "wrong"), wantStatus: http.StatusUnauthorized, wantError: "invalid_credentials"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"creds": {tt.creds}}
+			target := "/subscriptions/list"
+			if tt.inQuery {
+				target += "?" + form.Encode()
+				form = nil
+			}
+			w := post(env.h.ListSubscriptionsHandler, target, form)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantError != "" && !strings.Contains(w.Body.String(), `"error":"`+tt.wantError+`"`) {
+				t.Errorf("body does not report %s: %s", tt.wantError, w.Body)
+			}
+		})
+	}
+}
+
+func TestGenerateICSHandlerLegacyLinks(t *testing.T) {
+	env := newTestEnv(t, nil)
+	plaintext := "student@cpe.fr" + env.h.cfg.Credentials.Separator + "password"
+
+	// Links created before envelopes were raw RSA-OAEP in standard base64, later prefixed with the key ID
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &testKey.PublicKey, []byte(plaintext), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := base64.StdEncoding.EncodeToString(ciphertext)
+
+	for name, creds := range map[string]string{
+		"raw RSA":                  raw,
+		"key ID prefix":            env.h.keyring.Current().ID + "." + raw,
+		"separator in an envelope": env.sealPayload(t, []byte(plaintext)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := get(env.h.GenerateICSHandler, "/your-cpe-calendar.ics?creds="+url.QueryEscape(creds), nil)
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "BEGIN:VEVENT") {
+				t.Errorf("status %d: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+// postID runs a subscription handler addressing the feed id with the credentials of username
+func (e *testEnv) postID(t *testing.T, handler http.HandlerFunc, id, action, username string) *httptest.ResponseRecorder {
+	t.Helper()
+	form := url.Values{"creds": {e.seal(t, username, "password")}}
+	r := httptest.NewRequest(http.MethodPost, "/subscriptions/"+id+"/"+action, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r = mux.SetURLVars(r, map[string]string{"id": id})
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestSubscriptionHandlersAreScopedToTheOwner(t *testing.T) {
+	env := newTestEnv(t, withVault)
+	f := env.createFeed(t, "student@cpe.fr")
+	id := vault.TokenID(f.Token)
+
+	// Another student cannot see, revoke nor rotate the feed
+	w := post(env.h.ListSubscriptionsHandler, "/subscriptions/list", url.Values{"creds": {env.seal(t, "empty@cpe.fr", "password")}})
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), id) {
+		t.Errorf("list of another account: status %d: %s", w.Code, w.Body)
+	}
+	if w := env.postID(t, env.h.RevokeSubscriptionHandler, id, "revoke", "empty@cpe.fr"); w.Code != http.StatusNotFound {
+		t.Errorf("revoke by another account: status %d: %s", w.Code, w.Body)
+	}
+	if w := env.postID(t, env.h.RotateSubscriptionHandler, id, "rotate", "empty@cpe.fr"); w.Code != http.StatusNotFound {
+		t.Errorf("rotate by another account: status %d: %s", w.Code, w.Body)
+	}
+
+	// The owner rotates it, the old link stops working
+	w = env.postID(t, env.h.RotateSubscriptionHandler, id, "rotate", "student@cpe.fr")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("rotate: status %d: %s", w.Code, w.Body)
+	}
+	var rotated feed
+	if err := json.NewDecoder(w.Body).Decode(&rotated); err != nil {
+		t.Fatal(err)
+	}
+	if w := env.getFeed(f.Token); w.Code != http.StatusNotFound {
+		t.Errorf("old link: status %d", w.Code)
+	}
+	if w := env.getFeed(rotated.Token); w.Code != http.StatusOK {
+		t.Errorf("new link: status %d", w.Code)
+	}
+}
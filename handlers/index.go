@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"cpe/calendar/logger"
+	"net/http"
+	"strings"
+)
+
+// Index renders the index.html template with the current public key
+func (h *Handlers) Index(w http.ResponseWriter, r *http.Request) {
+	log := logger.Ctx(r.Context())
+
+	// Advertise the current key, new links are encrypted with it and carry its ID
+	key := h.keyring.Current()
+	data := struct {
+		PublicKey string
+		KeyID     string
+		// Vault shows the subscription management, only available with token links
+		Vault bool
+	}{
+		PublicKey: strings.ReplaceAll(key.PublicKeyPEM(), "\n", ""),
+		KeyID:     key.ID,
+		Vault:     h.vault != nil,
+	}
+
+	if err := h.index.Execute(w, data); err != nil {
+		// Log error if template rendering fails
+		log.Error().Err(err).Msg("Error rendering template")
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"cpe/calendar/decrypt"
+	"cpe/calendar/logger"
+	"cpe/calendar/vault"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxCredentialsAge is how long after being encrypted credentials prove the identity of their owner
+	maxCredentialsAge = 5 * time.Minute
+	// maxClockSkew tolerates a browser clock running ahead of the server
+	maxClockSkew = time.Minute
+)
+
+// subscription is a feed as listed to its owner, without the token which is never stored
+type subscription struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastAccess *time.Time `json:"last_access"`
+	UserAgent  string     `json:"user_agent"`
+}
+
+// ListSubscriptionsHandler lists the feeds of the account proven by the 'creds' form value
+func (h *Handlers) ListSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.Ctx(r.Context())
+
+	creds, apiErr := h.authenticate(r)
+	if apiErr != nil {
+		writeJSONError(w, apiErr)
+		return
+	}
+
+	entries, err := h.vault.List(h.subscriberKey(creds.Username))
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Failed to list subscriptions")
+		writeJSONError(w, errInternal)
+		return
+	}
+
+	subscriptions := make([]subscription, len(entries))
+	for i, entry := range entries {
+		subscriptions[i] = subscription{
+			ID:        entry.ID,
+			CreatedAt: entry.CreatedAt,
+			UserAgent: entry.UserAgent,
+		}
+		if !entry.LastAccess.IsZero() {
+			subscriptions[i].LastAccess = &entry.LastAccess
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(struct {
+		Subscriptions []subscription `json:"subscriptions"`
+	}{
+		Subscriptions: subscriptions,
+	})
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Error writing subscriptions response")
+	}
+}
+
+// RevokeSubscriptionHandler deletes one feed of the account, for owners who lost the link
+func (h *Handlers) RevokeSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	creds, apiErr := h.authenticate(r)
+	if apiErr != nil {
+		writeJSONError(w, apiErr)
+		return
+	}
+
+	if err := h.vault.RevokeID(h.subscriberKey(creds.Username), id); err != nil {
+		h.subscriptionError(w, r, id, err)
+		return
+	}
+
+	logger.Ctx(r.Context()).Info().
+		Str("feed", shortID(id)).
+		Msg("Feed revoked by its owner")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeSubscriptionsHandler deletes every feed of the account
+func (h *Handlers) RevokeSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.Ctx(r.Context())
+
+	creds, apiErr := h.authenticate(r)
+	if apiErr != nil {
+		writeJSONError(w, apiErr)
+		return
+	}
+
+	revoked, err := h.vault.RevokeAll(h.subscriberKey(creds.Username))
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("Failed to revoke subscriptions")
+		writeJSONError(w, errInternal)
+		return
+	}
+
+	log.Info().
+		Str("user", logger.HashUser(creds.Username)).
+		Int("revoked", revoked).
+		Msg("All feeds revoked by their owner")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateSubscriptionHandler replaces one feed of the account with a new link storing the credentials just proven
+func (h *Handlers) RotateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	creds, apiErr := h.authenticate(r)
+	if apiErr != nil {
+		writeJSONError(w, apiErr)
+		return
+	}
+
+	token, err := h.vault.Replace(h.subscriberKey(creds.Username), id, creds, time.Now())
+	if err != nil {
+		h.subscriptionError(w, r, id, err)
+		return
+	}
+
+	logger.Ctx(r.Context()).Info().
+		Str("feed", shortID(id)).
+		Str("newFeed", feedID(token)).
+		Msg("Feed rotated by its owner")
+	writeFeed(w, r, http.StatusCreated, token)
+}
+
+// authenticate proves the caller owns the account of the 'creds' form value by logging in to mycpe.
+// The credentials must have been encrypted moments ago: a calendar link carries them as well,
+// and whoever finds an old link must not be able to manage the feeds of its owner.
+func (h *Handlers) authenticate(r *http.Request) (decrypt.Credentials, *apiError) {
+	creds, apiErr := h.decodeCredentials(r, r.PostFormValue("creds"))
+	if apiErr != nil {
+		return decrypt.Credentials{}, apiErr
+	}
+
+	// Legacy payloads carry no creation date, they can only come from an old link
+	now := time.Now()
+	if creds.Version != decrypt.CredentialsVersion || creds.CreatedAt.IsZero() ||
+		now.Sub(creds.CreatedAt) > maxCredentialsAge || creds.CreatedAt.Sub(now) > maxClockSkew {
+		logger.Ctx(r.Context()).Warn().
+			Str("user", logger.HashUser(creds.Username)).
+			Int("version", creds.Version).
+			Time("createdAt", creds.CreatedAt).
+			Msg("Rejected credentials that were not freshly encrypted")
+		return decrypt.Credentials{}, errStaleCredentials
+	}
+
+	// Check the password is still the current one
+	if _, err := h.client.LoginContext(r.Context(), creds.Username, creds.Password); err != nil {
+		logger.Ctx(r.Context()).Error().
+			Err(err).
+			Str("user", logger.HashUser(creds.Username)).
+			Msg("Failed to authenticate subscription owner")
+		return decrypt.Credentials{}, upstreamError(err)
+	}
+	return creds, nil
+}
+
+// subscriptionError reports a vault failure on a feed addressed by its ID
+func (h *Handlers) subscriptionError(w http.ResponseWriter, r *http.Request, id string, err error) {
+	if errors.Is(err, vault.ErrNotFound) {
+		logger.Ctx(r.Context()).Info().
+			Str("feed", shortID(id)).
+			Msg("Unknown subscription requested")
+		writeJSONError(w, errUnknownFeed)
+		return
+	}
+
+	logger.Ctx(r.Context()).Error().
+		Err(err).
+		Str("feed", shortID(id)).
+		Msg("Failed to update the vault")
+	writeJSONError(w, errInternal)
+}
+
+// shortID is the loggable form of a storage ID, the same as feedID of its token
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -1,168 +1,228 @@
 package handlers
 
 import (
+	"context"
+	"cpe/calendar/cache"
 	"cpe/calendar/decrypt"
+	"cpe/calendar/filter"
 	"cpe/calendar/ical"
+	"cpe/calendar/lesson"
 	"cpe/calendar/logger"
-	"cpe/calendar/request"
+	"cpe/calendar/types"
+	"fmt"
 	"net/http"
-	"os"
-	"strings"
+	"time"
 )
 
 func Health(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
-	logger.Log.Info().
+	logger.Ctx(r.Context()).Info().
 		Msg("Health check endpoint hit, status OK")
 }
 
-// GenerateICSHandler generates the ICS file and sends it in the response with a given filename
-func GenerateICSHandler(w http.ResponseWriter, r *http.Request) {
-	// Get start and end times from environment variables
-	start := os.Getenv("START_TIMESTAMP")
-	end := os.Getenv("END_TIMESTAMP")
-	separator := os.Getenv("SEPARATOR")
+// GenerateICSHandler generates the ICS file of the credentials carried by the 'creds' query param
+func (h *Handlers) GenerateICSHandler(w http.ResponseWriter, r *http.Request) {
+	creds, apiErr := h.decodeCredentials(r, r.URL.Query().Get("creds"))
+	if apiErr != nil {
+		writeError(w, r, apiErr)
+		return
+	}
+
+	h.serveCalendar(w, r, creds)
+}
 
-	// Log environment variables
-	logger.Log.Info().
-		Str("start", start).
-		Str("end", end).
-		Str("separator", separator).
-		Msg("Using environment variables for start, end, and separator")
+// serveCalendar generates the ICS file of creds and sends it in the response with a given filename
+func (h *Handlers) serveCalendar(w http.ResponseWriter, r *http.Request, creds decrypt.Credentials) {
+	log := logger.Ctx(r.Context())
 
 	filename := "cpe-calendar.ics"
 	calendarName := "CPE Calendar"
+	username, pass := creds.Username, creds.Password
 
-	// Get query param 'creds'
-	cryptedCreds := r.URL.Query().Get("creds")
-
-	// Load the RSA private key
-	privateKey, err := decrypt.LoadPrivateKey()
+	// Resolve the date window, overridden by the 'from' and 'to' query params or else by the link options
+	query := r.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	if from == "" {
+		from = creds.Options.From
+	}
+	if to == "" {
+		to = creds.Options.To
+	}
+	dateWindow, err := h.policy.Resolve(time.Now(), from, to)
 	if err != nil {
-		logger.Log.Error().
+		log.Error().
 			Err(err).
-			Msg("Error loading private key")
-		http.Error(w, "Failed to load private key", http.StatusInternalServerError)
+			Str("from", from).
+			Str("to", to).
+			Msg("Invalid date window requested")
+		writeError(w, r, errInvalidRange)
 		return
 	}
 
-	// Decrypt the message
-	decryptedMessage, err := decrypt.DecryptMessage(cryptedCreds, privateKey)
+	// Log the resolved window
+	log.Info().
+		Str("start", dateWindow.StartDate()).
+		Str("end", dateWindow.EndDate()).
+		Msg("Using date window")
+
+	// Parse the event filters of the query before asking mycpe anything
+	eventFilter, err := filter.Parse(query)
 	if err != nil {
-		logger.Log.Error().
+		log.Error().
 			Err(err).
-			Str("cryptedCreds", cryptedCreds).
-			Msg("Error decrypting message")
-		http.Error(w, "Invalid credentials", http.StatusBadRequest)
-		return
-	}
-
-	// Split the decrypted message using the separator
-	parts := strings.Split(decryptedMessage, separator)
-	if len(parts) < 2 {
-		logger.Log.Error().
-			Str("decryptedMessage", decryptedMessage).
-			Msg("Invalid credentials format")
-		http.Error(w, "Invalid credentials format", http.StatusBadRequest)
+			Msg("Invalid filter requested")
+		writeError(w, r, errInvalidFilter)
 		return
 	}
-	username := parts[0]
-	pass := parts[1]
-
-	// Log successful decryption of message
-	logger.Log.Info().
-		Str("username", username).
-		Msg("Credentials decrypted successfully")
 
-	// Fetch data from the source
-	events, err := request.FetchData(start, end, username, pass)
+	// Fetch data from the cache, or from the source when missing or stale
+	key := cache.Key(username, pass, dateWindow)
+	timetable, err := h.cache.Get(r.Context(), key, func(ctx context.Context) ([]types.Event, error) {
+		return h.client.FetchDataContext(ctx, dateWindow.Start, dateWindow.End, username, pass)
+	})
 	if err != nil {
-		logger.Log.Error().
+		log.Error().
 			Err(err).
-			Str("username", username).
+			Str("user", logger.HashUser(username)).
 			Msg("Failed to fetch data")
-		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		if r.Context().Err() != nil {
+			// The client went away, nobody is left to answer
+			return
+		}
+
+		apiErr := upstreamError(err)
+		if apiErr == errInvalidCredentials && h.cfg.Features.CredentialsNotice && !wantsJSON(r) {
+			// Calendar clients ignore error bodies, show the problem in the calendar itself
+			h.writeCredentialsNotice(w, r, calendarName, filename)
+			return
+		}
+		writeError(w, r, apiErr)
 		return
 	}
 
-	logger.Log.Info().
+	events := timetable.Events
+	lastModified := timetable.ModifiedAt
+
+	log.Info().
 		Int("eventsCount", len(events)).
 		Msg("Fetched events successfully")
 
-	// Generate the iCal file with the calendar name
-	icsContent := ical.GenerateICS(events, calendarName)
+	opts := h.calendarOptions(r)
+	opts.Stamp = timetable.FetchedAt
+
+	// mycpe repeats multi-day interventions on every day, keep one entry before anything is keyed on them
+	events = lesson.Dedupe(events)
+
+	// Track schedule changes against the last feed served to this subscriber
+	if h.history != nil {
+		tracked, revisions, err := h.history.Apply(h.subscriberKey(username), events, dateWindow.StartDate(), dateWindow.EndDate(), time.Now())
+		if err != nil {
+			log.Error().
+				Err(err).
+				Msg("Failed to apply schedule history")
+		} else {
+			events = tracked
+			opts.Revisions = revisions
+		}
+	}
+
+	// Normalize the events, every output is generated from lessons
+	lessons := lesson.ParseAll(events, h.cfg.Calendar.Location, log)
+
+	// Filter after the history so events left out of this feed are not recorded as cancelled
+	if !eventFilter.Empty() {
+		lessons = eventFilter.Apply(lessons)
+		log.Info().
+			Int("lessonsCount", len(lessons)).
+			Msg("Filtered lessons")
+	}
+
+	// Cancelled or changed events make the feed newer than the timetable itself
+	for _, revision := range opts.Revisions {
+		if revision.LastModified.After(lastModified) {
+			lastModified = revision.LastModified
+		}
+	}
+
+	// Set validators and caching headers before deciding whether a body is needed
+	etag := `W/"` + ical.Fingerprint(lessons, calendarName, opts) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(h.cfg.Cache.TTL.Seconds())))
+
+	if notModified(r, etag, lastModified) {
+		log.Info().
+			Str("etag", etag).
+			Msg("Calendar not modified")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
 	// Set headers for the iCal file response with the provided filename
-	w.Header().Set("Content-Type", "text/calendar")
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
 	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
 
+	// HEAD only needs the headers, skip serialization
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// Generate the iCal file with the calendar name
+	icsContent := ical.GenerateICS(lessons, calendarName, opts)
+
 	// Write the iCal content to the response
 	w.Write([]byte(icsContent))
 }
 
 // ValidateHandler validates the credentials and checks if the login is successful
-func ValidateHandler(w http.ResponseWriter, r *http.Request) {
-	separator := os.Getenv("SEPARATOR")
-
-	// Get query param 'creds'
-	cryptedCreds := r.URL.Query().Get("creds")
+func (h *Handlers) ValidateHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.Ctx(r.Context())
 
 	// Log incoming credentials request
-	logger.Log.Info().
-		Str("cryptedCreds", cryptedCreds).
+	log.Info().
+		Str("creds", logger.Fingerprint(r.URL.Query().Get("creds"))).
 		Msg("Validate credentials request received")
 
-	// Load the RSA private key
-	privateKey, err := decrypt.LoadPrivateKey()
-	if err != nil {
-		logger.Log.Error().
-			Err(err).
-			Msg("Error loading private key")
-		http.Error(w, "Failed to load private key", http.StatusInternalServerError)
-		return
-	}
-
-	// Decrypt the message
-	decryptedMessage, err := decrypt.DecryptMessage(cryptedCreds, privateKey)
-	if err != nil {
-		logger.Log.Error().
-			Err(err).
-			Str("cryptedCreds", cryptedCreds).
-			Msg("Error decrypting message")
-		http.Error(w, "Invalid credentials", http.StatusBadRequest)
+	creds, apiErr := h.decodeCredentials(r, r.URL.Query().Get("creds"))
+	if apiErr != nil {
+		writeJSONError(w, apiErr)
 		return
 	}
-
-	// Split the decrypted message using the separator
-	parts := strings.Split(decryptedMessage, separator)
-	if len(parts) < 2 {
-		logger.Log.Error().
-			Str("decryptedMessage", decryptedMessage).
-			Msg("Invalid credentials format")
-		http.Error(w, "Invalid credentials format", http.StatusBadRequest)
-		return
-	}
-	username := parts[0]
-	pass := parts[1]
-
-	// Log successful decryption
-	logger.Log.Info().
-		Str("username", username).
-		Msg("Credentials decrypted successfully")
+	username, pass := creds.Username, creds.Password
 
 	// Fetch data to validate credentials
-	_, err = request.Login(username, pass)
+	_, err := h.client.LoginContext(r.Context(), username, pass)
 	if err != nil {
-		logger.Log.Error().
+		log.Error().
 			Err(err).
-			Str("username", username).
+			Str("user", logger.HashUser(username)).
 			Msg("Failed to validate credentials")
-		w.WriteHeader(http.StatusUnauthorized)
+		writeJSONError(w, upstreamError(err))
 		return
 	}
 
-	logger.Log.Info().
-		Str("username", username).
+	log.Info().
+		Str("user", logger.HashUser(username)).
 		Msg("User validated successfully")
+
 	w.WriteHeader(http.StatusOK)
 }
+
+// writeCredentialsNotice serves a calendar with a single event asking the student to renew their link
+func (h *Handlers) writeCredentialsNotice(w http.ResponseWriter, r *http.Request, calendarName, filename string) {
+	opts := h.calendarOptions(r)
+
+	icsContent := ical.GenerateNotice(
+		calendarName,
+		"credentials-expired",
+		"CPE Calendar: please re-enter your password",
+		"Your CPE credentials were rejected, probably because your password changed. "+
+			"Generate a new calendar link on the CPE Calendar website and subscribe to it again.",
+		opts,
+	)
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(icsContent))
+}
@@ -0,0 +1,280 @@
+package history
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cpe/calendar/ical"
+	"cpe/calendar/logger"
+	"cpe/calendar/types"
+)
+
+// dateLayout is the YYYY-MM-DD prefix of mycpe datetimes and the format of the window bounds
+const dateLayout = "2006-01-02"
+
+// Record is what is remembered about one event of a subscriber's feed
+type Record struct {
+	Hash         string      `json:"hash"`
+	Sequence     int         `json:"sequence"`
+	LastModified time.Time   `json:"last_modified"`
+	CancelledAt  *time.Time  `json:"cancelled_at,omitempty"`
+	Event        types.Event `json:"event"`
+}
+
+// Store keeps the last feed served to each subscriber as one JSON file per subscriber
+type Store struct {
+	dir   string
+	grace time.Duration
+	mu    sync.Mutex
+}
+
+// NewStore creates a store in dir, keeping removed events as cancelled for the grace period
+func NewStore(dir string, grace time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	return &Store{dir: dir, grace: grace}, nil
+}
+
+// SubscriberKey derives the storage key of a subscriber from the decrypted username.
+// The key names files, the salt keeps it from being matched against guessed emails.
+// An empty salt gives the unsalted keys of earlier versions so existing files stay attached.
+func SubscriberKey(salt, username string) string {
+	name := strings.ToLower(strings.TrimSpace(username))
+	if salt == "" {
+		sum := sha256.Sum256([]byte(name))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Apply compares events with the last feed served to the subscriber, records the new state and
+// returns the events to serve, including recently removed ones, along with their revisions.
+// Only events starting between fromDate and toDate (YYYY-MM-DD, inclusive) can be considered removed,
+// so a narrower or shifted date window does not cancel events it simply did not ask for.
+func (s *Store) Apply(subscriber string, events []types.Event, fromDate, toDate string, now time.Time) ([]types.Event, map[string]ical.Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, err := s.load(subscriber)
+	if err != nil {
+		return events, nil, err
+	}
+
+	current := make(map[string]Record, len(events))
+	present := make(map[string]bool, len(events))
+	changed := false
+
+	// Create or update a record for every event currently published by mycpe.
+	// Multi-day interventions are deduped by the caller, see lesson.Dedupe. Other entries sharing
+	// a key are rare: the first one is tracked and the next ones are served untracked.
+	var untracked []types.Event
+	for _, event := range events {
+		if event.Favori == nil {
+			continue
+		}
+
+		key := ical.EventKey(event)
+		if present[key] {
+			untracked = append(untracked, event)
+			continue
+		}
+		hash := eventHash(event)
+
+		record, known := previous[key]
+		switch {
+		case !known:
+			record = Record{Hash: hash, LastModified: now}
+			changed = true
+		case record.Hash != hash || record.CancelledAt != nil:
+			record.Hash = hash
+			record.Sequence++
+			record.LastModified = now
+			record.CancelledAt = nil
+			changed = true
+		}
+		record.Event = event
+		current[key] = record
+		present[key] = true
+	}
+
+	// Past events are forgotten once they are older than the window by the grace period,
+	// otherwise every lesson rolling out of a rolling window would be kept forever
+	forgetBefore := fromDate
+	if from, err := time.Parse(dateLayout, fromDate); err == nil {
+		forgetBefore = from.Add(-s.grace).Format(dateLayout)
+	}
+
+	// Keep removed events as cancelled until the grace period is over
+	for key, record := range previous {
+		if _, ok := current[key]; ok {
+			continue
+		}
+
+		expired := record.CancelledAt != nil && now.Sub(*record.CancelledAt) > s.grace
+
+		if !inRange(record.Event.DateDebut, fromDate, toDate) {
+			// Outside the requested window: remember it untouched but do not serve it
+			if expired || startsBefore(record.Event.DateDebut, forgetBefore) {
+				changed = true
+			} else {
+				current[key] = record
+			}
+			continue
+		}
+
+		if record.CancelledAt == nil {
+			cancelledAt := now
+			record.CancelledAt = &cancelledAt
+			record.Sequence++
+			record.LastModified = now
+			changed = true
+
+			logger.Log.Info().
+				Str("eventKey", key).
+				Str("startDate", record.Event.DateDebut).
+				Msg("Event removed upstream, marking as cancelled")
+		} else if expired {
+			changed = true
+			continue
+		}
+		current[key] = record
+	}
+
+	if changed {
+		if err := s.save(subscriber, current); err != nil {
+			return events, nil, err
+		}
+	}
+
+	// Build the feed from the events currently published and the cancelled ones of the window
+	served := make([]types.Event, 0, len(current))
+	revisions := make(map[string]ical.Revision, len(current))
+	for key, record := range current {
+		cancelled := record.CancelledAt != nil
+		if !present[key] && !(cancelled && inRange(record.Event.DateDebut, fromDate, toDate)) {
+			continue
+		}
+
+		served = append(served, record.Event)
+		revisions[key] = ical.Revision{
+			Sequence:     record.Sequence,
+			LastModified: record.LastModified,
+			Cancelled:    cancelled,
+		}
+	}
+
+	// Keep events in chronological order like mycpe returns them
+	served = append(served, untracked...)
+	sort.SliceStable(served, func(i, j int) bool {
+		return served[i].DateDebut < served[j].DateDebut
+	})
+
+	// Events without favori data are not tracked but still passed through
+	for _, event := range events {
+		if event.Favori == nil {
+			served = append(served, event)
+		}
+	}
+
+	return served, revisions, nil
+}
+
+// load reads the records of a subscriber, an unknown subscriber has none
+func (s *Store) load(subscriber string) (map[string]Record, error) {
+	data, err := os.ReadFile(s.path(subscriber))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]Record{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	records := map[string]Record{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse history: %w", err)
+	}
+	return records, nil
+}
+
+// save atomically replaces the records of a subscriber
+func (s *Store) save(subscriber string, records map[string]Record) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, subscriber+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create history file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(subscriber)); err != nil {
+		return fmt.Errorf("failed to replace history file: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) path(subscriber string) string {
+	return filepath.Join(s.dir, subscriber+".json")
+}
+
+// eventHash hashes the fields of an event that matter to subscribers
+func eventHash(event types.Event) string {
+	data, _ := json.Marshal(struct {
+		Start        string        `json:"start"`
+		End          string        `json:"end"`
+		Favori       *types.Favori `json:"favori"`
+		Intervenants string        `json:"intervenants"`
+		Statut       *string       `json:"statut"`
+		Description  *string       `json:"description"`
+	}{
+		Start:        event.DateDebut,
+		End:          event.DateFin,
+		Favori:       event.Favori,
+		Intervenants: event.Intervenants,
+		Statut:       event.StatutIntervention,
+		Description:  event.Description,
+	})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// startsBefore reports whether a mycpe datetime falls on a day before date
+func startsBefore(dateTime, date string) bool {
+	if len(dateTime) < len(dateLayout) {
+		return false
+	}
+	return dateTime[:len(dateLayout)] < date
+}
+
+// inRange reports whether a mycpe datetime falls on a day between fromDate and toDate
+func inRange(dateTime, fromDate, toDate string) bool {
+	if len(dateTime) < len(dateLayout) {
+		return false
+	}
+	day := dateTime[:len(dateLayout)]
+	return day >= fromDate && day <= toDate
+}
@@ -0,0 +1,210 @@
+package history
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"cpe/calendar/types"
+)
+
+func event(id int64, start, end string) types.Event {
+	return types.Event{
+		ID:        &id,
+		DateDebut: start,
+		DateFin:   end,
+		Favori:    &types.Favori{F2: " | I300", F3: "Architecture", F4: "COUY", F5: "TD  "},
+	}
+}
+
+func TestApplyForgetsPastRecords(t *testing.T) {
+	store, err := NewStore(t.TempDir(), 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	past := event(1, "2025-01-06T08:00:00.000", "2025-01-06T10:00:00.000")
+	if _, _, err := store.Apply("s", []types.Event{past}, "2025-01-01", "2025-01-31", now); err != nil {
+		t.Fatal(err)
+	}
+
+	// A window a year later no longer lists the lesson, it must not be kept
+	later := now.AddDate(1, 0, 0)
+	current := event(2, "2026-01-12T08:00:00.000", "2026-01-12T10:00:00.000")
+	if _, _, err := store.Apply("s", []types.Event{current}, "2026-01-01", "2026-01-31", later); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := store.load("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := records["1"]; ok {
+		t.Errorf("record of 2025-01-06 is still stored for a 2026 window")
+	}
+	if _, ok := records["2"]; !ok {
+		t.Errorf("record of the current event is missing")
+	}
+}
+
+func TestApplyKeepsRecordsWithinGrace(t *testing.T) {
+	store, err := NewStore(t.TempDir(), 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	recent := event(1, "2025-01-06T08:00:00.000", "2025-01-06T10:00:00.000")
+	if _, _, err := store.Apply("s", []types.Event{recent}, "2025-01-01", "2025-01-31", now); err != nil {
+		t.Fatal(err)
+	}
+	// The window moved past the lesson by less than the grace period
+	if _, _, err := store.Apply("s", nil, "2025-01-10", "2025-02-10", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := store.load("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := records["1"]; !ok {
+		t.Errorf("record just outside the window was forgotten")
+	}
+}
+
+func TestApplyServesEntriesSharingAKey(t *testing.T) {
+	store, err := NewStore(t.TempDir(), 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Without multi-day bounds the entries are distinct occurrences, the feed must keep them all
+	days := []types.Event{
+		event(3, "2025-03-10T08:00:00.000", "2025-03-10T18:00:00.000"),
+		event(3, "2025-03-11T08:00:00.000", "2025-03-11T18:00:00.000"),
+		event(3, "2025-03-12T08:00:00.000", "2025-03-12T18:00:00.000"),
+	}
+	served, _, err := store.Apply("s", days, "2025-03-01", "2025-03-31", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != len(days) {
+		t.Errorf("served %d events, want %d", len(served), len(days))
+	}
+
+	info, err := os.Stat(store.path("s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Polling again with the same entries must not rewrite the file
+	_, revisions, err := store.Apply("s", days, "2025-03-01", "2025-03-31", now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := os.Stat(store.path("s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Saving renames a new file over the old one
+	if !os.SameFile(info, again) {
+		t.Errorf("history rewritten although nothing changed")
+	}
+	if revisions["3"].Sequence != 0 {
+		t.Errorf("sequence is %d, want 0", revisions["3"].Sequence)
+	}
+}
+
+func TestApplyBumpsSequenceOnChange(t *testing.T) {
+	store, err := NewStore(t.TempDir(), 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	lesson := event(4, "2025-03-10T08:00:00.000", "2025-03-10T10:00:00.000")
+	if _, _, err := store.Apply("s", []types.Event{lesson}, "2025-03-01", "2025-03-31", now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lesson moves to another room
+	moved := lesson
+	moved.Favori = &types.Favori{F2: " | E200", F3: lesson.Favori.F3, F4: lesson.Favori.F4, F5: lesson.Favori.F5}
+	later := now.Add(time.Hour)
+	_, revisions, err := store.Apply("s", []types.Event{moved}, "2025-03-01", "2025-03-31", later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revision := revisions["4"]
+	if revision.Sequence != 1 || !revision.LastModified.Equal(later) || revision.Cancelled {
+		t.Errorf("got %+v, want sequence 1 modified at %s", revision, later)
+	}
+
+	// Nothing changes on the next poll
+	_, revisions, err = store.Apply("s", []types.Event{moved}, "2025-03-01", "2025-03-31", later.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revisions["4"].Sequence != 1 {
+		t.Errorf("sequence is %d after an unchanged poll, want 1", revisions["4"].Sequence)
+	}
+}
+
+func TestApplyCancelsRemovedEvents(t *testing.T) {
+	grace := 7 * 24 * time.Hour
+	store, err := NewStore(t.TempDir(), grace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	kept := event(5, "2025-03-10T08:00:00.000", "2025-03-10T10:00:00.000")
+	removed := event(6, "2025-03-11T08:00:00.000", "2025-03-11T10:00:00.000")
+	if _, _, err := store.Apply("s", []types.Event{kept, removed}, "2025-03-01", "2025-03-31", now); err != nil {
+		t.Fatal(err)
+	}
+
+	// mycpe no longer lists the second lesson, it is served as cancelled
+	later := now.Add(time.Hour)
+	served, revisions, err := store.Apply("s", []types.Event{kept}, "2025-03-01", "2025-03-31", later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 2 {
+		t.Fatalf("served %d events, want the kept and the cancelled one", len(served))
+	}
+	revision := revisions["6"]
+	if !revision.Cancelled || revision.Sequence != 1 || !revision.LastModified.Equal(later) {
+		t.Errorf("got %+v, want cancelled with sequence 1", revision)
+	}
+
+	// Once the grace period is over it is no longer served
+	served, revisions, err = store.Apply("s", []types.Event{kept}, "2025-03-01", "2025-03-31", later.Add(grace+time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := revisions["6"]; ok || len(served) != 1 {
+		t.Errorf("cancelled event still served after the grace period")
+	}
+}
+
+func TestSubscriberKey(t *testing.T) {
+	unsalted := SubscriberKey("", "Student@cpe.fr ")
+	if unsalted != SubscriberKey("", "student@cpe.fr") {
+		t.Errorf("key depends on case and spaces")
+	}
+	// Without a salt the key is the one written by earlier versions, sha256 of the email
+	if want := "e7ebe3e962d97e23cc12ad8e7aaf283c08352e901645c439b16cb6dcd54a49ad"; unsalted != want {
+		t.Errorf("unsalted key %q, want %q", unsalted, want)
+	}
+
+	salted := SubscriberKey("pepper", "student@cpe.fr")
+	if salted == unsalted || salted == SubscriberKey("salt", "student@cpe.fr") {
+		t.Errorf("salt does not change the key")
+	}
+	if salted != SubscriberKey("pepper", " STUDENT@cpe.fr") {
+		t.Errorf("salted key depends on case and spaces")
+	}
+}
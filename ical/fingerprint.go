@@ -0,0 +1,51 @@
+package ical
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"cpe/calendar/lesson"
+)
+
+// Fingerprint returns a digest of everything GenerateICS output depends on, except the DTSTAMP.
+// It is cheap compared to serialization and is used as the feed's entity tag.
+func Fingerprint(lessons []lesson.Lesson, calendarName string, opts Options) string {
+	zone := ""
+	if opts.Location != nil {
+		zone = opts.Location.String()
+	}
+
+	// Lessons do not serialize their source event, the keys stand for it in the UIDs
+	keys := make([]string, len(lessons))
+	for i, l := range lessons {
+		keys[i] = EventKey(l.Event)
+	}
+
+	data, _ := json.Marshal(struct {
+		Keys      []string            `json:"keys"`
+		Lessons   []lesson.Lesson     `json:"lessons"`
+		Name      string              `json:"name"`
+		Zone      string              `json:"zone"`
+		LocalTime bool                `json:"local_time"`
+		UIDDomain string              `json:"uid_domain"`
+		Revisions map[string]Revision `json:"revisions"`
+		MultiDay  string              `json:"multi_day"`
+		EmptyDays string              `json:"empty_days"`
+		Breaks    string              `json:"breaks"`
+	}{
+		Keys:      keys,
+		Lessons:   lessons,
+		Name:      calendarName,
+		Zone:      zone,
+		LocalTime: opts.LocalTime,
+		UIDDomain: opts.UIDDomain,
+		Revisions: opts.Revisions,
+		MultiDay:  opts.MultiDay,
+		EmptyDays: opts.EmptyDays,
+		Breaks:    opts.Breaks,
+	})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
@@ -1,103 +1,182 @@
 package ical
 
 import (
+	"cpe/calendar/lesson"
 	"cpe/calendar/logger"
-	"cpe/calendar/types"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/rs/zerolog"
 )
 
-// GenerateICS generates an ICS string from a list of events
-func GenerateICS(events []types.Event, calendarName string) string {
-	// Start building the ICS string
-	ics := "BEGIN:VCALENDAR\n"
-	ics += "VERSION:2.0\n"
-	ics += "PRODID:-//github.com/qypol342 //CPE Calendar//EN\n"
-	ics += fmt.Sprintf("NAME:%s\n", calendarName)
-	ics += fmt.Sprintf("X-WR-CALNAME:%s\n", calendarName)
-	ics += fmt.Sprintf("Description:%s: %s\n", "CPE Calendar", calendarName)
-	ics += fmt.Sprintf("X-WR-CALDESC:%s: %s\n", "CPE Calendar", calendarName)
-	ics += "REFRESH-INTERVAL;VALUE=DURATION:PT1H\n"
-
-	// Define the layout for parsing the datetime with a timezone offset
-	const layout = "2006-01-02T15:04:05.000"
-
-	// Loop over each event and generate the calendar content
-	for _, event := range events {
-
-		if event.Favori == nil {
-			// Log skipped events due to missing Favori field
-			logger.Log.Warn().
-				Str("eventID", fmt.Sprintf("%v", event.ID)).
-				Msg("Skipping event due to missing Favori data")
-			continue
-		}
+// Options controls how the calendar is serialized
+type Options struct {
+	// Location is the time zone mycpe dates are expressed in, UTC when nil
+	Location *time.Location
+	// LocalTime emits DTSTART/DTEND with a TZID and an embedded VTIMEZONE instead of UTC
+	LocalTime bool
+	// UIDDomain is appended to event keys to build globally unique UIDs
+	UIDDomain string
+	// Stamp is the DTSTAMP of every event, the current time when zero
+	Stamp time.Time
+	// Revisions holds the change tracking state of events, keyed by EventKey
+	Revisions map[string]Revision
+	// MultiDay, EmptyDays and Breaks select how those entries are written, see MultiDaySpan,
+	// EmptyDaysDrop and BreaksDrop; the zero values span multi-day ones and drop the others
+	MultiDay  string
+	EmptyDays string
+	Breaks    string
+	// Log receives the generation logs, logger.Log when nil
+	Log *zerolog.Logger
+}
 
-		// Extract components from regex matches
-		location := event.Favori.F2
-		summary := event.Favori.F5 + event.Favori.F3
-		description := event.Favori.F4
-
-		// Parse the start and end times in the given time zone
-		start, err := time.Parse(layout, event.DateDebut)
-		if err != nil {
-			logger.Log.Error().
-				Err(err).
-				Int64("eventID", *event.ID).
-				Str("startDate", event.DateDebut).
-				Msg("Error parsing start time")
-			continue
-		}
+// logger returns the logger of the generation
+func (o Options) logger() *zerolog.Logger {
+	if o.Log != nil {
+		return o.Log
+	}
+	return &logger.Log
+}
 
-		end, err := time.Parse(layout, event.DateFin)
-		if err != nil {
-			logger.Log.Error().
-				Err(err).
-				Int64("eventID", *event.ID).
-				Str("endDate", event.DateFin).
-				Msg("Error parsing end time")
-			continue
-		}
+// Revision describes how an event changed since it was first served
+type Revision struct {
+	Sequence     int
+	LastModified time.Time
+	Cancelled    bool
+}
 
-		// Normalize start and end times to UTC
-		loc, err := time.LoadLocation("Europe/Paris")
-		if err != nil {
-			logger.Log.Error().
-				Err(err).
-				Msg("Error loading Paris time zone")
-			continue
-		}
+// GenerateICS generates an ICS string from a list of lessons, see lesson.ParseAll
+func GenerateICS(lessons []lesson.Lesson, calendarName string, opts Options) string {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	localTime := opts.LocalTime && loc != time.UTC
+	log := opts.logger()
 
-		start = time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), start.Minute(), start.Second(), 0, loc).UTC()
-		end = time.Date(end.Year(), end.Month(), end.Day(), end.Hour(), end.Minute(), end.Second(), 0, loc).UTC()
+	stamp := opts.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+
+	var ics strings.Builder
+	w := NewWriter(&ics)
+
+	// Start building the ICS content
+	w.Begin("VCALENDAR")
+	w.Property("VERSION", "2.0")
+	w.Text("PRODID", "-//github.com/qypol342 //CPE Calendar//EN")
+	w.Text("NAME", calendarName)
+	w.Text("X-WR-CALNAME", calendarName)
+	w.Text("DESCRIPTION", fmt.Sprintf("%s: %s", "CPE Calendar", calendarName))
+	w.Text("X-WR-CALDESC", fmt.Sprintf("%s: %s", "CPE Calendar", calendarName))
+	w.Property("REFRESH-INTERVAL", "PT1H", NewParam("VALUE", "DURATION"))
+
+	// Apply the multi-day, empty day and break options first, they change the covered range
+	occurrences := opts.occurrences(lessons)
+
+	if localTime {
+		w.Text("X-WR-TIMEZONE", loc.String())
+		from, to := occurrenceRange(occurrences)
+		writeTimezone(w, loc, from, to)
+	}
+
+	// Loop over each lesson and generate the calendar content
+	for _, occ := range occurrences {
+		l := occ.lesson
+		event := l.Event
+
+		location := l.Location()
+		summary := occ.summary
+		description := strings.Join(l.Teachers, ", ")
+
+		revision := opts.Revisions[EventKey(event)]
+		if revision.Cancelled {
+			summary = "Cancelled: " + summary
+		}
 
 		// Log event details
-		logger.Log.Info().
-			Int64("eventID", *event.ID).
+		log.Info().
+			Str("eventKey", EventKey(event)).
 			Str("summary", summary).
-			Str("start", start.String()).
-			Str("startUTC", start.UTC().String()).
-			Str("end", end.String()).
+			Str("start", occ.start.String()).
+			Str("startUTC", occ.start.UTC().String()).
+			Str("end", occ.end.String()).
+			Bool("allDay", occ.allDay).
 			Msg("Event processed for ICS generation")
 
-		// Add event details to ICS string
-		ics += "BEGIN:VEVENT\n"
-		ics += fmt.Sprintf("UID:%d\n", event.ID)
-		ics += fmt.Sprintf("DTSTART:%s\n", start.Format("20060102T150405Z"))
-		ics += fmt.Sprintf("DTEND:%s\n", end.Format("20060102T150405Z"))
-		ics += fmt.Sprintf("LOCATION:%s\n", location)
-		ics += fmt.Sprintf("SUMMARY:%s\n", summary)
-		ics += fmt.Sprintf("DESCRIPTION:%s\n", description)
-		ics += "END:VEVENT\n"
+		// Add event details to the calendar
+		w.Begin("VEVENT")
+		w.Text("UID", EventUID(event, opts.UIDDomain))
+		w.Property("DTSTAMP", stamp.UTC().Format("20060102T150405Z"))
+		if occ.allDay {
+			w.Property("DTSTART", occ.start.Format("20060102"), NewParam("VALUE", "DATE"))
+			w.Property("DTEND", occ.end.Format("20060102"), NewParam("VALUE", "DATE"))
+		} else {
+			writeDateTime(w, "DTSTART", occ.start, localTime)
+			writeDateTime(w, "DTEND", occ.end, localTime)
+		}
+		w.Text("LOCATION", location)
+		w.Text("SUMMARY", summary)
+		w.Text("DESCRIPTION", description)
+		if revision.Sequence > 0 {
+			w.Property("SEQUENCE", strconv.Itoa(revision.Sequence))
+		}
+		if !revision.LastModified.IsZero() {
+			w.Property("LAST-MODIFIED", revision.LastModified.UTC().Format("20060102T150405Z"))
+		}
+		if revision.Cancelled {
+			w.Property("STATUS", "CANCELLED")
+		}
+		if occ.transparent {
+			w.Property("TRANSP", "TRANSPARENT")
+		}
+		w.End("VEVENT")
 	}
 
 	// Close the VCALENDAR block
-	ics += "END:VCALENDAR\n"
+	w.End("VCALENDAR")
+
+	if err := w.Flush(); err != nil {
+		log.Error().
+			Err(err).
+			Msg("Error writing ICS content")
+	}
 
 	// Log the successful generation of the ICS content
-	logger.Log.Info().
-		Int("eventCount", len(events)).
+	log.Info().
+		Int("eventCount", len(occurrences)).
 		Msg("Generated ICS content successfully")
 
-	return ics
+	return ics.String()
+}
+
+// writeDateTime writes a DATE-TIME property either in UTC or as local time referencing the VTIMEZONE
+func writeDateTime(w *Writer, name string, t time.Time, localTime bool) {
+	if localTime {
+		w.Property(name, t.Format(localLayout), NewParam("TZID", t.Location().String()))
+		return
+	}
+	w.Property(name, t.UTC().Format("20060102T150405Z"))
+}
+
+// occurrenceRange returns the period covered by the occurrences, or the current day when there are none
+func occurrenceRange(occurrences []occurrence) (time.Time, time.Time) {
+	if len(occurrences) == 0 {
+		now := time.Now()
+		return now, now.Add(24 * time.Hour)
+	}
+
+	from, to := occurrences[0].start, occurrences[0].end
+	for _, occ := range occurrences[1:] {
+		if occ.start.Before(from) {
+			from = occ.start
+		}
+		if occ.end.After(to) {
+			to = occ.end
+		}
+	}
+	return from, to
 }
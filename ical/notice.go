@@ -0,0 +1,60 @@
+package ical
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GenerateNotice generates a calendar holding a single all-day event for today, used to tell
+// subscribers about a problem with their feed in the only place they will look at.
+// The UID is derived from name so the notice replaces itself instead of piling up.
+func GenerateNotice(calendarName, name, summary, description string, opts Options) string {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	stamp := opts.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+	today := stamp.In(loc)
+
+	var ics strings.Builder
+	w := NewWriter(&ics)
+
+	w.Begin("VCALENDAR")
+	w.Property("VERSION", "2.0")
+	w.Text("PRODID", "-//github.com/qypol342 //CPE Calendar//EN")
+	w.Text("NAME", calendarName)
+	w.Text("X-WR-CALNAME", calendarName)
+	w.Text("DESCRIPTION", fmt.Sprintf("%s: %s", "CPE Calendar", calendarName))
+	w.Text("X-WR-CALDESC", fmt.Sprintf("%s: %s", "CPE Calendar", calendarName))
+	w.Property("REFRESH-INTERVAL", "PT1H", NewParam("VALUE", "DURATION"))
+
+	domain := opts.UIDDomain
+	if domain == "" {
+		domain = defaultUIDDomain
+	}
+
+	w.Begin("VEVENT")
+	w.Text("UID", name+"@"+domain)
+	w.Property("DTSTAMP", stamp.UTC().Format("20060102T150405Z"))
+	w.Property("DTSTART", today.Format("20060102"), NewParam("VALUE", "DATE"))
+	w.Property("DTEND", today.AddDate(0, 0, 1).Format("20060102"), NewParam("VALUE", "DATE"))
+	w.Text("SUMMARY", summary)
+	w.Text("DESCRIPTION", description)
+	w.Property("TRANSP", "TRANSPARENT")
+	w.End("VEVENT")
+
+	w.End("VCALENDAR")
+
+	if err := w.Flush(); err != nil {
+		opts.logger().Error().
+			Err(err).
+			Msg("Error writing ICS notice")
+	}
+
+	return ics.String()
+}
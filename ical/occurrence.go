@@ -0,0 +1,93 @@
+package ical
+
+import (
+	"time"
+
+	"cpe/calendar/lesson"
+)
+
+// How multi-day interventions are written
+const (
+	// MultiDaySpan writes a single timed event from the first start to the last end
+	MultiDaySpan = "span"
+	// MultiDayAllDay writes a single all-day event covering every day of the intervention
+	MultiDayAllDay = "all_day"
+)
+
+// How the empty day placeholders of mycpe are written
+const (
+	// EmptyDaysDrop leaves them out of the calendar
+	EmptyDaysDrop = "drop"
+	// EmptyDaysFreeDay writes them as transparent all-day "Free day" events
+	EmptyDaysFreeDay = "free_day"
+)
+
+// How breaks are written
+const (
+	// BreaksDrop leaves them out of the calendar
+	BreaksDrop = "drop"
+	// BreaksTransparent writes them as events that do not make the subscriber busy
+	BreaksTransparent = "transparent"
+)
+
+// occurrence is a lesson as it appears in the calendar once the options are applied
+type occurrence struct {
+	lesson lesson.Lesson
+	start  time.Time
+	// end is exclusive, the day after the last one for all-day occurrences
+	end         time.Time
+	allDay      bool
+	transparent bool
+	summary     string
+}
+
+// occurrences applies the multi-day, empty day and break options to lessons
+func (o Options) occurrences(lessons []lesson.Lesson) []occurrence {
+	occurrences := make([]occurrence, 0, len(lessons))
+	for _, l := range lessons {
+		occ := occurrence{lesson: l, start: l.Start, end: l.End, summary: l.Title()}
+
+		switch {
+		case l.Empty:
+			if o.EmptyDays != EmptyDaysFreeDay {
+				continue
+			}
+			occ.start, occ.end = day(l.Start), day(l.Start).AddDate(0, 0, 1)
+			occ.allDay, occ.transparent = true, true
+			occ.summary = "Free day"
+
+		case l.Break:
+			if o.Breaks != BreaksTransparent {
+				continue
+			}
+			occ.transparent = true
+			if occ.summary == "" {
+				occ.summary = "Break"
+			}
+
+		case l.MultiDay():
+			occ.start, occ.end = l.MultiDayStart, l.MultiDayEnd
+			if o.MultiDay == MultiDayAllDay {
+				occ.start, occ.end = day(l.MultiDayStart), dayEnd(l.MultiDayEnd)
+				occ.allDay = true
+			}
+		}
+
+		occurrences = append(occurrences, occ)
+	}
+	return occurrences
+}
+
+// day returns the midnight starting the day of t, in its location
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// dayEnd returns the midnight closing the last day reached by the exclusive end t, t itself when it is a midnight
+func dayEnd(t time.Time) time.Time {
+	d := day(t)
+	if d.Equal(t) {
+		return d
+	}
+	return d.AddDate(0, 0, 1)
+}
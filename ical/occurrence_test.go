@@ -0,0 +1,80 @@
+package ical
+
+import (
+	"testing"
+	"time"
+
+	"cpe/calendar/lesson"
+	"cpe/calendar/types"
+)
+
+func TestOccurrencesMultiDay(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiDay := func(start, end string) lesson.Lesson {
+		l, err := lesson.Parse(types.Event{
+			DateDebut:           "2025-02-17T08:00:00.000",
+			DateFin:             "2025-02-17T18:00:00.000",
+			DateDebutMultijours: &start,
+			DateFinMultijours:   &end,
+			Favori:              &types.Favori{F3: "Projet transverse", F5: "Projet"},
+		}, paris)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+
+	tests := []struct {
+		name      string
+		lesson    lesson.Lesson
+		mode      string
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "timed span",
+			lesson:    multiDay("2025-02-17T08:00:00.000", "2025-02-19T18:00:00.000"),
+			mode:      MultiDaySpan,
+			wantStart: time.Date(2025, 2, 17, 8, 0, 0, 0, paris),
+			wantEnd:   time.Date(2025, 2, 19, 18, 0, 0, 0, paris),
+		},
+		{
+			name:      "date only span covers the last day",
+			lesson:    multiDay("2025-02-17", "2025-02-19"),
+			mode:      MultiDaySpan,
+			wantStart: time.Date(2025, 2, 17, 0, 0, 0, 0, paris),
+			wantEnd:   time.Date(2025, 2, 20, 0, 0, 0, 0, paris),
+		},
+		{
+			name:      "timed all-day",
+			lesson:    multiDay("2025-02-17T08:00:00.000", "2025-02-19T18:00:00.000"),
+			mode:      MultiDayAllDay,
+			wantStart: time.Date(2025, 2, 17, 0, 0, 0, 0, paris),
+			wantEnd:   time.Date(2025, 2, 20, 0, 0, 0, 0, paris),
+		},
+		{
+			// The exclusive end already is a midnight, no extra day is added
+			name:      "date only all-day",
+			lesson:    multiDay("2025-02-17", "2025-02-19"),
+			mode:      MultiDayAllDay,
+			wantStart: time.Date(2025, 2, 17, 0, 0, 0, 0, paris),
+			wantEnd:   time.Date(2025, 2, 20, 0, 0, 0, 0, paris),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			occurrences := Options{MultiDay: tt.mode}.occurrences([]lesson.Lesson{tt.lesson})
+			if len(occurrences) != 1 {
+				t.Fatalf("%d occurrences, want 1", len(occurrences))
+			}
+			occ := occurrences[0]
+			if !occ.start.Equal(tt.wantStart) || !occ.end.Equal(tt.wantEnd) {
+				t.Errorf("got %s to %s, want %s to %s", occ.start, occ.end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package ical
+
+import (
+	"fmt"
+	"time"
+)
+
+// localLayout is the iCalendar DATE-TIME form used for local (TZID) and floating times
+const localLayout = "20060102T150405"
+
+// writeTimezone writes a VTIMEZONE component describing loc between from and to.
+// Every UTC offset transition in the range gets its own STANDARD or DAYLIGHT observance,
+// taken from Go's tzdata, so no recurrence rule has to be guessed.
+func writeTimezone(w *Writer, loc *time.Location, from, to time.Time) {
+	w.Begin("VTIMEZONE")
+	w.Property("TZID", loc.String())
+
+	// Observance in effect at the beginning of the range
+	first := from.In(loc)
+	zoneStart, _ := first.ZoneBounds()
+	if zoneStart.IsZero() {
+		zoneStart = first
+	}
+	_, offset := first.Zone()
+	_, previousOffset := zoneStart.Add(-time.Second).Zone()
+	writeObservance(w, zoneStart.In(loc), previousOffset)
+
+	// Every transition until the end of the range
+	current := first
+	for {
+		_, next := current.ZoneBounds()
+		if next.IsZero() || next.After(to) {
+			break
+		}
+		next = next.In(loc)
+		writeObservance(w, next, offset)
+
+		_, offset = next.Zone()
+		current = next
+	}
+
+	w.End("VTIMEZONE")
+}
+
+// writeObservance writes the STANDARD or DAYLIGHT sub-component starting at onset
+func writeObservance(w *Writer, onset time.Time, offsetFrom int) {
+	name, offsetTo := onset.Zone()
+
+	component := "STANDARD"
+	if onset.IsDST() {
+		component = "DAYLIGHT"
+	}
+
+	// DTSTART is expressed in the local time that was in effect before the onset
+	localOnset := onset.In(time.FixedZone("", offsetFrom))
+
+	w.Begin(component)
+	w.Property("DTSTART", localOnset.Format(localLayout))
+	w.Property("TZOFFSETFROM", formatOffset(offsetFrom))
+	w.Property("TZOFFSETTO", formatOffset(offsetTo))
+	w.Text("TZNAME", name)
+	w.End(component)
+}
+
+// formatOffset formats a UTC offset in seconds as the iCalendar UTC-OFFSET value (+HHMM)
+func formatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+
+	hours := seconds / 3600
+	minutes := seconds % 3600 / 60
+	if rest := seconds % 60; rest != 0 {
+		return fmt.Sprintf("%c%02d%02d%02d", sign, hours, minutes, rest)
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, hours, minutes)
+}
@@ -0,0 +1,37 @@
+package ical
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"cpe/calendar/types"
+)
+
+// defaultUIDDomain is used as the right-hand side of UIDs when no domain is configured
+const defaultUIDDomain = "cpe-calendar"
+
+// EventKey returns a stable identifier for an event: the mycpe intervention ID when present,
+// otherwise a hash of the fields that describe the event so ID-less entries do not collide.
+func EventKey(event types.Event) string {
+	if event.ID != nil {
+		return strconv.FormatInt(*event.ID, 10)
+	}
+
+	fields := []string{event.DateDebut, event.DateFin, event.Intervenants}
+	if event.Favori != nil {
+		fields = append(fields, event.Favori.F2, event.Favori.F3, event.Favori.F4, event.Favori.F5)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return "h" + hex.EncodeToString(sum[:10])
+}
+
+// EventUID returns the globally unique UID of an event in the form <key>@<domain>
+func EventUID(event types.Event, domain string) string {
+	if domain == "" {
+		domain = defaultUIDDomain
+	}
+	return EventKey(event) + "@" + domain
+}
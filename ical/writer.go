@@ -0,0 +1,135 @@
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineOctets is the maximum length of a content line, excluding the CRLF (RFC 5545 §3.1)
+const maxLineOctets = 75
+
+// Param is a single property parameter such as TZID=Europe/Paris or VALUE=DATE
+type Param struct {
+	Name   string
+	Values []string
+}
+
+// NewParam builds a property parameter with one or more values
+func NewParam(name string, values ...string) Param {
+	return Param{Name: name, Values: values}
+}
+
+// Writer serializes iCalendar content lines following RFC 5545:
+// CRLF line endings, folding at 75 octets on UTF-8 boundaries and TEXT escaping.
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+// NewWriter returns a Writer writing content lines to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Begin opens a component, e.g. VCALENDAR or VEVENT
+func (cw *Writer) Begin(component string) {
+	cw.Property("BEGIN", component)
+}
+
+// End closes a component previously opened with Begin
+func (cw *Writer) End(component string) {
+	cw.Property("END", component)
+}
+
+// Property writes a property whose value is already in its final form (dates, durations, enums...)
+func (cw *Writer) Property(name, value string, params ...Param) {
+	var line strings.Builder
+	line.WriteString(name)
+	for _, param := range params {
+		line.WriteByte(';')
+		line.WriteString(param.Name)
+		line.WriteByte('=')
+		for i, value := range param.Values {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			line.WriteString(quoteParamValue(value))
+		}
+	}
+	line.WriteByte(':')
+	line.WriteString(value)
+
+	cw.writeLine(line.String())
+}
+
+// Text writes a property of type TEXT, escaping its value
+func (cw *Writer) Text(name, value string, params ...Param) {
+	cw.Property(name, EscapeText(value), params...)
+}
+
+// Flush writes any buffered data and returns the first error encountered
+func (cw *Writer) Flush() error {
+	if cw.err != nil {
+		return cw.err
+	}
+	cw.err = cw.w.Flush()
+	return cw.err
+}
+
+// writeLine folds a content line and writes it terminated by CRLF
+func (cw *Writer) writeLine(line string) {
+	if cw.err != nil {
+		return
+	}
+
+	limit := maxLineOctets
+	for len(line) > limit {
+		// Never split a multi-byte UTF-8 sequence across two lines
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			// Not UTF-8, no rune start to fold at: cut at the limit rather than loop forever
+			cut = limit
+		}
+
+		if _, cw.err = cw.w.WriteString(line[:cut] + "\r\n "); cw.err != nil {
+			return
+		}
+		line = line[cut:]
+		// Continuation lines start with a space, which counts towards the limit
+		limit = maxLineOctets - 1
+	}
+
+	_, cw.err = cw.w.WriteString(line + "\r\n")
+}
+
+// textEscaper escapes TEXT values as described in RFC 5545 §3.3.11
+var textEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`;`, `\;`,
+	`,`, `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+	"\r", `\n`,
+)
+
+// EscapeText escapes backslashes, semicolons, commas and newlines in a TEXT value
+func EscapeText(value string) string {
+	return textEscaper.Replace(value)
+}
+
+// quoteParamValue quotes a parameter value when it contains characters that are not allowed unquoted
+func quoteParamValue(value string) string {
+	// DQUOTE cannot be represented in a parameter value, drop it
+	value = strings.ReplaceAll(value, `"`, "")
+	value = strings.NewReplacer("\r", "", "\n", " ").Replace(value)
+
+	if strings.ContainsAny(value, ":;,") {
+		return `"` + value + `"`
+	}
+	return value
+}
@@ -0,0 +1,222 @@
+package ical
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"cpe/calendar/lesson"
+	"cpe/calendar/types"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// golden compares got with testdata/name, or rewrites it with -update
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("missing golden file, run go test ./ical -update: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the output:\n%s", path, got)
+	}
+}
+
+func TestWriterGolden(t *testing.T) {
+	var out strings.Builder
+	w := NewWriter(&out)
+	w.Begin("VEVENT")
+	w.Text("SUMMARY", "CM Réseaux; routage, commutation")
+	w.Text("DESCRIPTION", "Line one\nLine two with a backslash \\ and a comma, then more text to exceed seventy-five octets")
+	w.Text("LOCATION", strings.Repeat("é", 60))
+	w.Property("DTSTART", "20250217T080000", NewParam("TZID", "Europe/Paris"))
+	w.Property("X-PARAM", "value", NewParam("X-LIST", "a:b", "c;d", `e"f`))
+	w.End("VEVENT")
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	golden(t, "writer.ics", out.String())
+}
+
+func TestWriterFoldsOnRuneBoundaries(t *testing.T) {
+	tests := map[string]string{
+		"two bytes":   strings.Repeat("é", 100),
+		"three bytes": strings.Repeat("€", 100),
+		"four bytes":  strings.Repeat("😀", 100),
+		"mixed":       strings.Repeat("aé€😀", 40),
+	}
+
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			var out strings.Builder
+			w := NewWriter(&out)
+			w.Text("SUMMARY", value)
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n")
+			var unfolded strings.Builder
+			for i, line := range lines {
+				if len(line) > maxLineOctets {
+					t.Errorf("line %d is %d octets long", i, len(line))
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line %d splits a UTF-8 sequence: %q", i, line)
+				}
+				if i > 0 {
+					if !strings.HasPrefix(line, " ") {
+						t.Fatalf("continuation line %d does not start with a space", i)
+					}
+					line = line[1:]
+				}
+				unfolded.WriteString(line)
+			}
+			if unfolded.String() != "SUMMARY:"+value {
+				t.Errorf("unfolded line differs from the input")
+			}
+		})
+	}
+}
+
+func TestWriterFoldsInvalidUTF8(t *testing.T) {
+	done := make(chan string)
+	go func() {
+		var out strings.Builder
+		w := NewWriter(&out)
+		w.Property("X-RAW", strings.Repeat("\x80", 100))
+		w.Flush()
+		done <- out.String()
+	}()
+
+	select {
+	case out := <-done:
+		for i, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+			if len(line) > maxLineOctets {
+				t.Errorf("line %d is %d octets long", i, len(line))
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("writer did not terminate on invalid UTF-8")
+	}
+}
+
+func TestGenerateICSGolden(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := int64(19103546)
+	events := []types.Event{
+		{
+			ID:           &id,
+			DateDebut:    "2025-02-17T13:30:00.000",
+			DateFin:      "2025-02-17T15:30:00.000",
+			Duree:        "2:00",
+			Intervenants: "MARTIN, DUPONT",
+			Favori:       &types.Favori{F1: 19103546, F2: " | E200, E201", F3: "Réseaux; routage, commutation", F4: "MARTIN, DUPONT", F5: "CM  "},
+		},
+		{
+			DateDebut: "2025-03-03T08:00:00.000",
+			DateFin:   "2025-03-03T10:00:00.000",
+			Duree:     "2:00",
+			Favori:    &types.Favori{F2: " | A1-Amphi Hubert Curien", F3: "Mathématiques \\ Probabilités, une matière au titre très long pour forcer le pliage", F4: "LEROY", F5: "Examen  "},
+		},
+	}
+	lessons := make([]lesson.Lesson, 0, len(events))
+	for _, event := range events {
+		l, err := lesson.Parse(event, paris)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lessons = append(lessons, l)
+	}
+
+	stamp := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	for name, opts := range map[string]Options{
+		"calendar_utc.ics":   {Location: paris, UIDDomain: "example.org", Stamp: stamp},
+		"calendar_local.ics": {Location: paris, LocalTime: true, UIDDomain: "example.org", Stamp: stamp},
+	} {
+		t.Run(name, func(t *testing.T) {
+			golden(t, name, GenerateICS(lessons, "CPE Calendar", opts))
+		})
+	}
+}
+
+func TestGenerateICSGoldenDST(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lessons on both sides of the 2025-03-30 and 2025-10-26 transitions
+	var lessons []lesson.Lesson
+	for _, date := range []string{"2025-03-28", "2025-03-31", "2025-10-24", "2025-10-27"} {
+		l, err := lesson.Parse(types.Event{
+			DateDebut: date + "T08:00:00.000",
+			DateFin:   date + "T10:00:00.000",
+			Duree:     "2:00",
+			Favori:    &types.Favori{F2: " | E200", F3: "Réseaux", F4: "MARTIN", F5: "CM  "},
+		}, paris)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lessons = append(lessons, l)
+	}
+
+	stamp := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	got := GenerateICS(lessons, "CPE Calendar", Options{Location: paris, LocalTime: true, UIDDomain: "example.org", Stamp: stamp})
+	golden(t, "calendar_dst.ics", got)
+
+	// DTSTART of each observance is the wall clock time before the change
+	timezone := strings.Join([]string{
+		"BEGIN:VTIMEZONE",
+		"TZID:Europe/Paris",
+		"BEGIN:STANDARD",
+		"DTSTART:20241027T030000",
+		"TZOFFSETFROM:+0200",
+		"TZOFFSETTO:+0100",
+		"TZNAME:CET",
+		"END:STANDARD",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:20250330T020000",
+		"TZOFFSETFROM:+0100",
+		"TZOFFSETTO:+0200",
+		"TZNAME:CEST",
+		"END:DAYLIGHT",
+		"BEGIN:STANDARD",
+		"DTSTART:20251026T030000",
+		"TZOFFSETFROM:+0200",
+		"TZOFFSETTO:+0100",
+		"TZNAME:CET",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+	}, "\r\n")
+	if !strings.Contains(got, timezone) {
+		t.Errorf("VTIMEZONE does not describe both transitions, want:\n%s", timezone)
+	}
+	for _, start := range []string{"20250328T080000", "20250331T080000", "20251024T080000", "20251027T080000"} {
+		if !strings.Contains(got, "DTSTART;TZID=Europe/Paris:"+start+"\r\n") {
+			t.Errorf("missing lesson starting at %s local time", start)
+		}
+	}
+
+	// In UTC the same lessons move by an hour across each transition
+	utc := GenerateICS(lessons, "CPE Calendar", Options{Location: paris, UIDDomain: "example.org", Stamp: stamp})
+	for _, start := range []string{"20250328T070000Z", "20250331T060000Z", "20251024T060000Z", "20251027T070000Z"} {
+		if !strings.Contains(utc, "DTSTART:"+start+"\r\n") {
+			t.Errorf("missing lesson starting at %s", start)
+		}
+	}
+}
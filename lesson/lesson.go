@@ -0,0 +1,295 @@
+// Package lesson turns the raw mycpe planning entries into normalized lessons,
+// the model every output format is generated from.
+package lesson
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cpe/calendar/types"
+
+	"github.com/rs/zerolog"
+)
+
+// DateTimeLayout is the layout of the mycpe dates, expressed in the school time zone
+const DateTimeLayout = "2006-01-02T15:04:05.000"
+
+// dateLayout is accepted for the multi-day dates, which may carry no time
+const dateLayout = "2006-01-02"
+
+// Kind is the normalized activity type of a lesson
+type Kind string
+
+// Kinds of lessons, named after the usual mycpe labels
+const (
+	KindLecture   Kind = "CM"
+	KindTutorial  Kind = "TD"
+	KindPractical Kind = "TP"
+	KindExam      Kind = "Exam"
+	KindProject   Kind = "Project"
+	// KindOther covers every other activity, e.g. "Cours FHES" language sessions
+	KindOther Kind = "Other"
+)
+
+// kinds maps the upper-cased Favori.F5 labels to their kind
+var kinds = map[string]Kind{
+	"CM":      KindLecture,
+	"TD":      KindTutorial,
+	"TP":      KindPractical,
+	"EXAMEN":  KindExam,
+	"EXAM":    KindExam,
+	"DS":      KindExam,
+	"PARTIEL": KindExam,
+	"PROJET":  KindProject,
+	"PROJECT": KindProject,
+}
+
+var (
+	// ErrNoDetails is returned for entries without Favori data other than empty days and breaks
+	ErrNoDetails = errors.New("entry has no lesson details")
+	// ErrInvalidDate is returned when a date of the entry cannot be parsed
+	ErrInvalidDate = errors.New("invalid lesson date")
+)
+
+// Lesson is a normalized planning entry
+type Lesson struct {
+	// Event is the mycpe entry the lesson was parsed from, which identifies it (see ical.EventKey)
+	Event types.Event `json:"-"`
+
+	Kind Kind `json:"kind"`
+	// Label is the activity type as written by mycpe, e.g. "Cours FHES"
+	Label    string   `json:"label"`
+	Subject  string   `json:"subject"`
+	Teachers []string `json:"teachers"`
+	Rooms    []string `json:"rooms"`
+	// Building is the building of the first room, e.g. "E" for E200 or "A1" for A1-Amphi
+	Building string `json:"building"`
+	// Group is the part of Favori.F2 before the rooms, usually empty
+	Group string `json:"group"`
+
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+	// MultiDayStart and MultiDayEnd bound interventions spanning several days, zero otherwise.
+	// MultiDayEnd is exclusive: the midnight after the last day when mycpe gives no end time.
+	MultiDayStart time.Time `json:"multi_day_start"`
+	MultiDayEnd   time.Time `json:"multi_day_end"`
+
+	Break bool `json:"break"`
+	Empty bool `json:"empty"`
+}
+
+// Parse normalizes a mycpe entry, its dates being expressed in loc (UTC when nil)
+func Parse(event types.Event, loc *time.Location) (Lesson, error) {
+	// Empty day placeholders and breaks come without details, every other entry needs them
+	favori := event.Favori
+	if favori == nil {
+		if !event.IsEmpty && !event.IsBreak {
+			return Lesson{}, ErrNoDetails
+		}
+		favori = &types.Favori{}
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	start, err := time.ParseInLocation(DateTimeLayout, event.DateDebut, loc)
+	if err != nil {
+		return Lesson{}, fmt.Errorf("%w: start %q", ErrInvalidDate, event.DateDebut)
+	}
+	end, err := time.ParseInLocation(DateTimeLayout, event.DateFin, loc)
+	if err != nil {
+		return Lesson{}, fmt.Errorf("%w: end %q", ErrInvalidDate, event.DateFin)
+	}
+
+	l := Lesson{
+		Event:    event,
+		Label:    clean(favori.F5),
+		Subject:  clean(favori.F3),
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start),
+		Break:    event.IsBreak,
+		Empty:    event.IsEmpty,
+	}
+	l.Kind = kindOf(l.Label)
+
+	// Teachers come from Favori.F4, the intervenants field is a fallback
+	teachers := favori.F4
+	if clean(teachers) == "" {
+		teachers = event.Intervenants
+	}
+	l.Teachers = split(teachers)
+
+	// Favori.F2 is "<group> | <room>, <room>"
+	group, rooms, found := strings.Cut(favori.F2, "|")
+	if !found {
+		group, rooms = "", favori.F2
+	}
+	l.Group = clean(group)
+	l.Rooms = split(rooms)
+	if len(l.Rooms) > 0 {
+		l.Building = building(l.Rooms[0])
+	}
+
+	// Prefer the announced duration, the dates remain the reference when it is missing or malformed
+	if duration, ok := parseDuration(event.Duree); ok {
+		l.Duration = duration
+	}
+
+	if event.DateDebutMultijours != nil && event.DateFinMultijours != nil {
+		l.MultiDayStart, err = parseDate(*event.DateDebutMultijours, loc, false)
+		if err != nil {
+			return Lesson{}, fmt.Errorf("%w: multi-day start %q", ErrInvalidDate, *event.DateDebutMultijours)
+		}
+		l.MultiDayEnd, err = parseDate(*event.DateFinMultijours, loc, true)
+		if err != nil {
+			return Lesson{}, fmt.Errorf("%w: multi-day end %q", ErrInvalidDate, *event.DateFinMultijours)
+		}
+	}
+
+	return l, nil
+}
+
+// multiDayKey identifies a multi-day intervention across the daily entries repeating it
+type multiDayKey struct {
+	title      string
+	start, end string
+}
+
+// Dedupe keeps the first daily entry of each multi-day intervention, mycpe repeating it on every day.
+// It runs before anything keyed on the entries, so the feed is the same whether history is tracked or not.
+func Dedupe(events []types.Event) []types.Event {
+	deduped := make([]types.Event, 0, len(events))
+	multiDays := map[multiDayKey]bool{}
+	for _, event := range events {
+		if event.DateDebutMultijours != nil && event.DateFinMultijours != nil {
+			key := multiDayKey{start: *event.DateDebutMultijours, end: *event.DateFinMultijours}
+			if event.Favori != nil {
+				key.title = strings.TrimSpace(clean(event.Favori.F5) + " " + clean(event.Favori.F3))
+			}
+			if multiDays[key] {
+				continue
+			}
+			multiDays[key] = true
+		}
+		deduped = append(deduped, event)
+	}
+	return deduped
+}
+
+// ParseAll normalizes the entries of a planning, skipping and logging the ones that cannot be.
+// Multi-day interventions are expected to be deduped already, see Dedupe.
+func ParseAll(events []types.Event, loc *time.Location, log *zerolog.Logger) []Lesson {
+	lessons := make([]Lesson, 0, len(events))
+	for _, event := range events {
+		l, err := Parse(event, loc)
+		if errors.Is(err, ErrNoDetails) {
+			// Empty days and breaks never get here, the ID tells which entry mycpe sent without details
+			entry := log.Warn().
+				Str("startDate", event.DateDebut)
+			if event.ID != nil {
+				entry = entry.Int64("id", *event.ID)
+			}
+			entry.Msg("Skipping event due to missing Favori data")
+			continue
+		}
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("startDate", event.DateDebut).
+				Str("endDate", event.DateFin).
+				Msg("Error parsing event")
+			continue
+		}
+		lessons = append(lessons, l)
+	}
+	return lessons
+}
+
+// Title returns the title of the lesson, e.g. "TD Architecture et Langages du Web"
+func (l Lesson) Title() string {
+	return strings.TrimSpace(l.Label + " " + l.Subject)
+}
+
+// Location returns the rooms of the lesson as a single line
+func (l Lesson) Location() string {
+	return strings.Join(l.Rooms, ", ")
+}
+
+// MultiDay reports whether the lesson spans several days
+func (l Lesson) MultiDay() bool {
+	return !l.MultiDayStart.IsZero() && !l.MultiDayEnd.IsZero()
+}
+
+// kindOf returns the kind of an activity label
+func kindOf(label string) Kind {
+	if kind, ok := kinds[strings.ToUpper(label)]; ok {
+		return kind
+	}
+	return KindOther
+}
+
+// building returns the building of a room: the prefix of "A1-Amphi", or the letters of "E200"
+func building(room string) string {
+	if prefix, _, found := strings.Cut(room, "-"); found {
+		return strings.TrimSpace(prefix)
+	}
+	letters := strings.TrimRightFunc(room, func(r rune) bool {
+		return r >= '0' && r <= '9'
+	})
+	if letters == room {
+		// No room number, the name is not a building code
+		return ""
+	}
+	return letters
+}
+
+// parseDuration parses the "H:MM" durations of mycpe
+func parseDuration(s string) (time.Duration, bool) {
+	hours, minutes, found := strings.Cut(strings.TrimSpace(s), ":")
+	if !found {
+		return 0, false
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 {
+		return 0, false
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m >= 60 {
+		return 0, false
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, true
+}
+
+// parseDate parses a multi-day bound, with or without a time.
+// A date alone covers the whole day, as an end it is the midnight of the next one.
+func parseDate(s string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation(DateTimeLayout, s, loc); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(dateLayout, s, loc)
+	if err != nil || !end {
+		return t, err
+	}
+	return t.AddDate(0, 0, 1), nil
+}
+
+// clean trims s and collapses its inner runs of spaces
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// split returns the non-blank comma separated items of s
+func split(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = clean(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
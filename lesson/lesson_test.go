@@ -0,0 +1,293 @@
+package lesson
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"cpe/calendar/types"
+
+	"github.com/rs/zerolog"
+)
+
+func ptr(s string) *string {
+	return &s
+}
+
+// entry is a mycpe entry of 2025-02-17 13:30-15:30 with the given details
+func entry(favori *types.Favori) types.Event {
+	return types.Event{
+		DateDebut: "2025-02-17T13:30:00.000",
+		DateFin:   "2025-02-17T15:30:00.000",
+		Duree:     "2:00",
+		Favori:    favori,
+	}
+}
+
+func TestParse(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		event types.Event
+		want  Lesson
+	}{
+		{
+			name:  "lecture",
+			event: entry(&types.Favori{F2: " | E200, E201", F3: "Réseaux", F4: "MARTIN, DUPONT", F5: "CM  "}),
+			want: Lesson{Kind: KindLecture, Label: "CM", Subject: "Réseaux", Teachers: []string{"MARTIN", "DUPONT"},
+				Rooms: []string{"E200", "E201"}, Building: "E", Duration: 2 * time.Hour},
+		},
+		{
+			name:  "exam in an amphitheatre",
+			event: entry(&types.Favori{F2: " | A1-Amphi Hubert Curien", F3: "Mathématiques", F4: "LEROY", F5: "Examen"}),
+			want: Lesson{Kind: KindExam, Label: "Examen", Subject: "Mathématiques", Teachers: []string{"LEROY"},
+				Rooms: []string{"A1-Amphi Hubert Curien"}, Building: "A1", Duration: 2 * time.Hour},
+		},
+		{
+			name:  "lower case label",
+			event: entry(&types.Favori{F3: "Projet transverse", F5: "projet"}),
+			want:  Lesson{Kind: KindProject, Label: "projet", Subject: "Projet transverse", Duration: 2 * time.Hour},
+		},
+		{
+			name:  "unknown label",
+			event: entry(&types.Favori{F3: "Anglais", F5: "Cours  FHES"}),
+			want:  Lesson{Kind: KindOther, Label: "Cours FHES", Subject: "Anglais", Duration: 2 * time.Hour},
+		},
+		{
+			name: "teachers from the intervenants",
+			event: func() types.Event {
+				e := entry(&types.Favori{F3: "Physique", F4: "  ", F5: "TD"})
+				e.Intervenants = "BERNARD,  , PETIT "
+				return e
+			}(),
+			want: Lesson{Kind: KindTutorial, Label: "TD", Subject: "Physique", Teachers: []string{"BERNARD", "PETIT"}, Duration: 2 * time.Hour},
+		},
+		{
+			name:  "group before the rooms",
+			event: entry(&types.Favori{F2: "Groupe  B | C101", F3: "Chimie", F5: "TP"}),
+			want: Lesson{Kind: KindPractical, Label: "TP", Subject: "Chimie", Group: "Groupe B",
+				Rooms: []string{"C101"}, Building: "C", Duration: 2 * time.Hour},
+		},
+		{
+			name:  "rooms without separator",
+			event: entry(&types.Favori{F2: "Salle informatique", F3: "Chimie", F5: "TP"}),
+			want: Lesson{Kind: KindPractical, Label: "TP", Subject: "Chimie",
+				Rooms: []string{"Salle informatique"}, Duration: 2 * time.Hour},
+		},
+		{
+			name: "malformed duration falls back to the dates",
+			event: func() types.Event {
+				e := entry(&types.Favori{F3: "Chimie", F5: "TP"})
+				e.Duree = "2h"
+				return e
+			}(),
+			want: Lesson{Kind: KindPractical, Label: "TP", Subject: "Chimie", Duration: 2 * time.Hour},
+		},
+		{
+			name: "announced duration differs from the dates",
+			event: func() types.Event {
+				e := entry(&types.Favori{F3: "Chimie", F5: "TP"})
+				e.Duree = "1:45"
+				return e
+			}(),
+			want: Lesson{Kind: KindPractical, Label: "TP", Subject: "Chimie", Duration: time.Hour + 45*time.Minute},
+		},
+		{
+			name: "empty day",
+			event: func() types.Event {
+				e := entry(nil)
+				e.IsEmpty = true
+				return e
+			}(),
+			want: Lesson{Kind: KindOther, Empty: true, Duration: 2 * time.Hour},
+		},
+		{
+			name: "break",
+			event: func() types.Event {
+				e := entry(nil)
+				e.IsBreak = true
+				return e
+			}(),
+			want: Lesson{Kind: KindOther, Break: true, Duration: 2 * time.Hour},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.event, paris)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.want.Start = time.Date(2025, 2, 17, 13, 30, 0, 0, paris)
+			tt.want.End = time.Date(2025, 2, 17, 15, 30, 0, 0, paris)
+			if got.Kind != tt.want.Kind || got.Label != tt.want.Label || got.Subject != tt.want.Subject {
+				t.Errorf("got %s %q %q, want %s %q %q", got.Kind, got.Label, got.Subject, tt.want.Kind, tt.want.Label, tt.want.Subject)
+			}
+			if !slices.Equal(got.Teachers, tt.want.Teachers) {
+				t.Errorf("teachers %q, want %q", got.Teachers, tt.want.Teachers)
+			}
+			if !slices.Equal(got.Rooms, tt.want.Rooms) || got.Building != tt.want.Building || got.Group != tt.want.Group {
+				t.Errorf("rooms %q in %q for %q, want %q in %q for %q", got.Rooms, got.Building, got.Group, tt.want.Rooms, tt.want.Building, tt.want.Group)
+			}
+			if !got.Start.Equal(tt.want.Start) || !got.End.Equal(tt.want.End) || got.Duration != tt.want.Duration {
+				t.Errorf("%s to %s lasting %s, want %s to %s lasting %s", got.Start, got.End, got.Duration, tt.want.Start, tt.want.End, tt.want.Duration)
+			}
+			if got.Empty != tt.want.Empty || got.Break != tt.want.Break {
+				t.Errorf("empty %t break %t, want %t %t", got.Empty, got.Break, tt.want.Empty, tt.want.Break)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	multiDay := func(start, end string) types.Event {
+		e := entry(&types.Favori{F3: "Projet", F5: "Projet"})
+		e.DateDebutMultijours, e.DateFinMultijours = ptr(start), ptr(end)
+		return e
+	}
+
+	tests := []struct {
+		name  string
+		event types.Event
+		want  error
+	}{
+		{name: "no details", event: entry(nil), want: ErrNoDetails},
+		{name: "invalid start", event: types.Event{DateDebut: "17/02/2025 13:30", DateFin: "2025-02-17T15:30:00.000", Favori: &types.Favori{}}, want: ErrInvalidDate},
+		{name: "invalid end", event: types.Event{DateDebut: "2025-02-17T13:30:00.000", DateFin: "", Favori: &types.Favori{}}, want: ErrInvalidDate},
+		{name: "invalid multi-day start", event: multiDay("2025-02-31", "2025-02-21"), want: ErrInvalidDate},
+		{name: "invalid multi-day end", event: multiDay("2025-02-17", "soon"), want: ErrInvalidDate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.event, time.UTC); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+	id := int64(19103546)
+	missing := entry(nil)
+	missing.ID = &id
+
+	events := []types.Event{
+		entry(&types.Favori{F3: "Réseaux", F5: "CM"}),
+		missing,
+		{DateDebut: "invalid", DateFin: "invalid", Favori: &types.Favori{F3: "Chimie", F5: "TP"}},
+		entry(&types.Favori{F3: "Chimie", F5: "TP"}),
+	}
+
+	lessons := ParseAll(events, time.UTC, &log)
+	var titles []string
+	for _, l := range lessons {
+		titles = append(titles, l.Title())
+	}
+	if want := []string{"CM Réseaux", "TP Chimie"}; !slices.Equal(titles, want) {
+		t.Errorf("got %q, want %q", titles, want)
+	}
+	if !strings.Contains(logs.String(), `"id":19103546,"message":"Skipping event due to missing Favori data"`) {
+		t.Errorf("skipped entry not identified:\n%s", logs.String())
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	id := int64(42)
+	// mycpe lists a multi-day intervention once per day
+	day := func(date, subject string) types.Event {
+		return types.Event{
+			ID:                  &id,
+			DateDebut:           date + "T08:00:00.000",
+			DateFin:             date + "T18:00:00.000",
+			DateDebutMultijours: ptr("2025-02-17T08:00:00.000"),
+			DateFinMultijours:   ptr("2025-02-19T18:00:00.000"),
+			Favori:              &types.Favori{F3: subject, F5: "Projet"},
+		}
+	}
+	// Entries sharing an ID without multi-day bounds are distinct occurrences
+	repeated := func(date string) types.Event {
+		e := entry(&types.Favori{F3: "Anglais", F5: "Cours FHES"})
+		e.ID = &id
+		e.DateDebut, e.DateFin = date+"T08:00:00.000", date+"T10:00:00.000"
+		return e
+	}
+
+	events := []types.Event{
+		day("2025-02-17", "Projet transverse"),
+		repeated("2025-02-17"),
+		day("2025-02-18", "Projet transverse"),
+		repeated("2025-02-18"),
+		day("2025-02-18", "Projet personnel"),
+		day("2025-02-19", "Projet transverse"),
+	}
+
+	var got []string
+	for _, e := range Dedupe(events) {
+		got = append(got, e.DateDebut[:10]+" "+e.Favori.F3)
+	}
+	want := []string{
+		"2025-02-17 Projet transverse",
+		"2025-02-17 Anglais",
+		"2025-02-18 Anglais",
+		"2025-02-18 Projet personnel",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseMultiDayBounds(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		start, end string
+		wantStart  time.Time
+		wantEnd    time.Time
+	}{
+		{
+			name:  "dates and times",
+			start: "2025-02-17T08:00:00.000", end: "2025-02-19T18:00:00.000",
+			wantStart: time.Date(2025, 2, 17, 8, 0, 0, 0, paris), wantEnd: time.Date(2025, 2, 19, 18, 0, 0, 0, paris),
+		},
+		{
+			// The last day is included, the end is the midnight after it
+			name:  "dates only",
+			start: "2025-02-17", end: "2025-02-19",
+			wantStart: time.Date(2025, 2, 17, 0, 0, 0, 0, paris), wantEnd: time.Date(2025, 2, 20, 0, 0, 0, 0, paris),
+		},
+		{
+			name:  "date only end across a DST change",
+			start: "2025-03-28T08:00:00.000", end: "2025-03-30",
+			wantStart: time.Date(2025, 3, 28, 8, 0, 0, 0, paris), wantEnd: time.Date(2025, 3, 31, 0, 0, 0, 0, paris),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := entry(&types.Favori{F3: "Projet transverse", F5: "Projet"})
+			e.DateDebutMultijours, e.DateFinMultijours = ptr(tt.start), ptr(tt.end)
+			l, err := Parse(e, paris)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !l.MultiDayStart.Equal(tt.wantStart) || !l.MultiDayEnd.Equal(tt.wantEnd) {
+				t.Errorf("got %s to %s, want %s to %s", l.MultiDayStart, l.MultiDayEnd, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/rs/zerolog"
+)
+
+// RequestIDHeader carries the request ID from clients, in responses and to mycpe
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID restricts the IDs accepted from clients so they cannot forge log fields
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+type contextKey struct{}
+
+type requestIDKey struct{}
+
+// WithContext returns a copy of ctx carrying l
+func WithContext(ctx context.Context, l zerolog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, &l)
+}
+
+// Ctx returns the logger carried by ctx, or Log when there is none
+func Ctx(ctx context.Context) *zerolog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*zerolog.Logger); ok {
+		return l
+	}
+	return &Log
+}
+
+// RequestID returns the ID of the request ctx belongs to, empty outside of a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random 16 hex characters identifier
+func NewRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Middleware gives every request a child logger tagged with a request ID, see Ctx.
+// The ID sent by the client in X-Request-ID is kept when valid and is echoed in the response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		child := Log.With().Str("requestId", id).Logger()
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = WithContext(ctx, child)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
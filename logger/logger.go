@@ -1,20 +1,106 @@
+// Package logger holds the application zerolog logger and its configuration.
+// Log is usable right away and writes JSON to stdout until Setup applies the configuration.
 package logger
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/pkgerrors"
 )
 
+// Outputs supported by Config.Output
+const (
+	OutputStdout = "stdout"
+	OutputFile   = "file"
+	OutputBoth   = "both"
+)
+
+// Formats supported by Config.Format
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
 var Log zerolog.Logger
 
+// Config describes where and how logs are written
+type Config struct {
+	// Level is the minimum level written: trace, debug, info, warn, error, fatal or panic
+	Level string
+	// Output is stdout, file or both
+	Output string
+	// Format of the stdout logs, json or console. The file is always JSON for promtail.
+	Format string
+	// File is the path of the log file
+	File string
+	// MaxSizeMB rotates the file once it grows past this size, 0 disables rotation
+	MaxSizeMB int
+	// MaxBackups is the number of rotated files kept next to the current one
+	MaxBackups int
+	// UserSalt is mixed into HashUser so pseudonyms cannot be reversed from guessed emails
+	UserSalt string
+}
+
 func init() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
-	file, err := os.OpenFile("log/app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		panic(err)
+	Log = zerolog.New(redactingWriter{out: os.Stdout}).With().Timestamp().Logger()
+}
+
+// DefaultConfig logs JSON at info level to stdout
+func DefaultConfig() Config {
+	return Config{
+		Level:      "info",
+		Output:     OutputStdout,
+		Format:     FormatJSON,
+		File:       filepath.Join("log", "app.log"),
+		MaxSizeMB:  100,
+		MaxBackups: 5,
 	}
-	Log = zerolog.New(file).With().Timestamp().Logger()
+}
+
+// Setup replaces Log with a logger built from cfg.
+// On error Log is left untouched so the caller can still report the problem.
+func Setup(cfg Config) error {
+	level, err := zerolog.ParseLevel(strings.ToLower(cfg.Level))
+	if err != nil || level == zerolog.NoLevel {
+		return fmt.Errorf("invalid log level %q", cfg.Level)
+	}
+
+	var console io.Writer
+	switch strings.ToLower(cfg.Format) {
+	case FormatJSON:
+		console = os.Stdout
+	case FormatConsole:
+		console = zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
+	default:
+		return fmt.Errorf("invalid log format %q, expected %s or %s", cfg.Format, FormatJSON, FormatConsole)
+	}
+
+	var out io.Writer
+	switch strings.ToLower(cfg.Output) {
+	case OutputStdout:
+		out = console
+	case OutputFile, OutputBoth:
+		file, err := openRotatingFile(cfg.File, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups)
+		if err != nil {
+			return err
+		}
+		out = file
+		if strings.ToLower(cfg.Output) == OutputBoth {
+			out = io.MultiWriter(console, file)
+		}
+	default:
+		return fmt.Errorf("invalid log output %q, expected %s, %s or %s", cfg.Output, OutputStdout, OutputFile, OutputBoth)
+	}
+
+	userSalt = cfg.UserSalt
+	Log = zerolog.New(redactingWriter{out: out}).Level(level).With().Timestamp().Logger()
+	return nil
 }
@@ -1,92 +1,90 @@
 package main
 
 import (
+	"cpe/calendar/config"
 	"cpe/calendar/handlers"
 	"cpe/calendar/logger"
 	"cpe/calendar/metrics"
-	"html/template"
+	"flag"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
+	_ "time/tzdata"
 
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var tpl *template.Template
-
 func init() {
-	// Load environment variables from .env file
-	err := godotenv.Load()
-	if err != nil {
-		// Log error and exit if environment variables can't be loaded
-		logger.Log.Warn().Err(err).Msg("Error loading .env file")
-	}
-
-	// Parse templates
-	tpl = template.Must(template.ParseFiles(filepath.Join("static", "index.html")))
-
 	prometheus.Register(metrics.TotalRequests)
 	prometheus.Register(metrics.ResponseStatus)
 	prometheus.Register(metrics.HttpDuration)
+	prometheus.Register(metrics.UpstreamRequests)
+	prometheus.Register(metrics.UpstreamRetries)
+	prometheus.Register(metrics.CircuitBreakerState)
 }
 
 func main() {
+	configFile := flag.String("config", "", "YAML configuration file, overrides CONFIG_FILE")
+	flag.Parse()
+
+	// Load the configuration from .env, the optional file and the environment, and stop on any mistake
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		logger.Log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+
+	// Configure the logger now that the configuration is known
+	if err := logger.Setup(cfg.LoggerConfig()); err != nil {
+		logger.Log.Fatal().Err(err).Msg("Invalid logger configuration")
+	}
+
+	h, err := handlers.New(cfg)
+	if err != nil {
+		logger.Log.Fatal().Err(err).Msg("Error creating handlers")
+	}
+
 	r := mux.NewRouter()
+	r.Use(logger.Middleware)
 	r.Use(metrics.PrometheusMiddleware)
-	r.Path("/metrics").Handler(promhttp.Handler())
+	r.Path("/metrics").Handler(promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		// OpenMetrics is needed to expose the request ID exemplars
+		EnableOpenMetrics: true,
+	}))
 
 	// Serve dynamic index page
-	r.HandleFunc("/", serveIndex).Methods("GET")
+	r.HandleFunc("/", h.Index).Methods("GET")
 
 	// Serve static files like JavaScript, CSS, images, etc.
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static/"))))
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(cfg.Server.StaticDir))))
 
 	// Serve calendar.ics route
-	r.HandleFunc("/your-cpe-calendar.ics", handlers.GenerateICSHandler).Methods("GET")
+	r.HandleFunc("/your-cpe-calendar.ics", h.GenerateICSHandler).Methods("GET", "HEAD")
+
+	// Opaque token links, only when credentials are stored server side
+	if cfg.Features.Vault {
+		r.HandleFunc("/cal", h.CreateFeedHandler).Methods("POST")
+		r.HandleFunc("/cal/{token:[A-Za-z0-9_-]+}.ics", h.FeedHandler).Methods("GET", "HEAD")
+		r.HandleFunc("/cal/{token:[A-Za-z0-9_-]+}/revoke", h.RevokeFeedHandler).Methods("POST")
+
+		// Subscription management, the owner proves their identity with freshly encrypted CPE credentials.
+		// Every call is a POST so the credentials travel in the body, never in a URL.
+		r.HandleFunc("/subscriptions/list", h.ListSubscriptionsHandler).Methods("POST")
+		r.HandleFunc("/subscriptions/revoke", h.RevokeSubscriptionsHandler).Methods("POST")
+		r.HandleFunc("/subscriptions/{id:[0-9a-f]+}/revoke", h.RevokeSubscriptionHandler).Methods("POST")
+		r.HandleFunc("/subscriptions/{id:[0-9a-f]+}/rotate", h.RotateSubscriptionHandler).Methods("POST")
+	}
 
 	//validate route
-	r.HandleFunc("/validate", handlers.ValidateHandler).Methods("GET")
+	r.HandleFunc("/validate", h.ValidateHandler).Methods("GET")
 
 	// check app health
 	r.HandleFunc("/health", handlers.Health).Methods("GET")
 
 	// Start HTTP server and log any errors that occur
-	logger.Log.Info().Msg("Starting server on :8080")
-	err := http.ListenAndServe(":8080", r)
+	logger.Log.Info().Str("listen", cfg.Server.Listen).Msg("Starting server")
+	err = http.ListenAndServe(cfg.Server.Listen, r)
 	if err != nil {
 		// Log any errors that occur while starting the server
 		logger.Log.Fatal().Err(err).Msg("Error starting server")
 	}
 }
-
-// serveIndex renders the index.html Go template with environment variables
-func serveIndex(w http.ResponseWriter, r *http.Request) {
-	publicKey, err := os.ReadFile(filepath.Join("static", "public.pem"))
-	if err != nil {
-		// Log error if public key can't be read
-		logger.Log.Error().Err(err).Msg("Error reading public.pem")
-		http.Error(w, "Error reading public key", http.StatusInternalServerError)
-		return
-	}
-
-	publicKey = []byte(strings.ReplaceAll(string(publicKey), "\n", ""))
-	separator := os.Getenv("SEPARATOR")
-
-	data := struct {
-		PublicKey string
-		Separator string
-	}{
-		PublicKey: string(publicKey),
-		Separator: separator,
-	}
-
-	if err := tpl.Execute(w, data); err != nil {
-		// Log error if template rendering fails
-		logger.Log.Error().Err(err).Msg("Error rendering template")
-		http.Error(w, "Error rendering template", http.StatusInternalServerError)
-	}
-}
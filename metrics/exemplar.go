@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"context"
+
+	"cpe/calendar/logger"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// exemplarLabels links a sample to the request it was recorded for, nil outside of a request
+func exemplarLabels(ctx context.Context) prometheus.Labels {
+	id := logger.RequestID(ctx)
+	if id == "" {
+		return nil
+	}
+	return prometheus.Labels{"request_id": id}
+}
+
+// IncWithExemplar increments counter, attaching the request ID of ctx as an exemplar
+func IncWithExemplar(ctx context.Context, counter prometheus.Counter) {
+	labels := exemplarLabels(ctx)
+	adder, ok := counter.(prometheus.ExemplarAdder)
+	if labels == nil || !ok {
+		counter.Inc()
+		return
+	}
+	adder.AddWithExemplar(1, labels)
+}
+
+// ObserveWithExemplar records value in observer, attaching the request ID of ctx as an exemplar
+func ObserveWithExemplar(ctx context.Context, observer prometheus.Observer, value float64) {
+	labels := exemplarLabels(ctx)
+	exemplarObserver, ok := observer.(prometheus.ExemplarObserver)
+	if labels == nil || !ok {
+		observer.Observe(value)
+		return
+	}
+	exemplarObserver.ObserveWithExemplar(value, labels)
+}
@@ -3,6 +3,7 @@ package metrics
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
@@ -44,20 +45,43 @@ var HttpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 	Help: "Duration of HTTP requests.",
 }, []string{"path"})
 
+var UpstreamRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mycpe_requests_total",
+		Help: "Number of calls to mycpe by endpoint and outcome.",
+	},
+	[]string{"endpoint", "outcome"},
+)
+
+var UpstreamRetries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mycpe_retries_total",
+		Help: "Number of retried calls to mycpe.",
+	},
+	[]string{"endpoint"},
+)
+
+var CircuitBreakerState = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "mycpe_circuit_breaker_state",
+		Help: "State of the mycpe circuit breaker: 0 closed, 1 half-open, 2 open.",
+	},
+)
+
 func PrometheusMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		route := mux.CurrentRoute(r)
 		path, _ := route.GetPathTemplate()
 
-		timer := prometheus.NewTimer(HttpDuration.WithLabelValues(path))
+		start := time.Now()
 		rw := NewResponseWriter(w)
 		next.ServeHTTP(rw, r)
 
 		statusCode := rw.statusCode
 
 		ResponseStatus.WithLabelValues(strconv.Itoa(statusCode)).Inc()
-		TotalRequests.WithLabelValues(path).Inc()
+		IncWithExemplar(r.Context(), TotalRequests.WithLabelValues(path))
 
-		timer.ObserveDuration()
+		ObserveWithExemplar(r.Context(), HttpDuration.WithLabelValues(path), time.Since(start).Seconds())
 	})
 }
@@ -0,0 +1,259 @@
+// Package mockcpe is a stand-in for the mycpe mobile API, serving fixture timetables.
+// It is used by cmd/mockcpe for local development and can be mounted on an httptest.Server.
+package mockcpe
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// embedded holds the fixture timetables shipped with the package
+//
+//go:embed fixtures/*.json
+var embedded embed.FS
+
+// Fixtures returns the embedded fixture files
+func Fixtures() fs.FS {
+	sub, _ := fs.Sub(embedded, "fixtures")
+	return sub
+}
+
+// User is an account known by the mock, as described in users.json
+type User struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	ID       int64  `json:"id"`
+	// Planning is the fixture file returned by mon_planning
+	Planning string `json:"planning"`
+	// PlanningStatus forces the status code of mon_planning when set, e.g. 502
+	PlanningStatus int `json:"planning_status"`
+}
+
+// Server implements /mobile/login and /mobile/mon_planning
+type Server struct {
+	// FixedDates serves the fixture dates as written instead of moving them to the current weeks
+	FixedDates bool
+
+	fixtures fs.FS
+	users    []User
+	mu       sync.Mutex
+	tokens   map[string]User
+	mux      *http.ServeMux
+}
+
+// NewServer loads users.json from fixtures and returns the mock API
+func NewServer(fixtures fs.FS) (*Server, error) {
+	data, err := fs.ReadFile(fixtures, "users.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users fixture: %w", err)
+	}
+
+	var users []User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("failed to parse users fixture: %w", err)
+	}
+
+	s := &Server{
+		fixtures: fixtures,
+		users:    users,
+		tokens:   map[string]User{},
+		mux:      http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/mobile/login", s.login)
+	s.mux.HandleFunc("/mobile/mon_planning", s.planning)
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Revoke invalidates every token so the next mon_planning call answers 401
+func (s *Server) Revoke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]User{}
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Login    string `json:"login"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	for _, user := range s.users {
+		if user.Login == body.Login && user.Password == body.Password {
+			writeJSON(w, r, map[string]string{
+				"normal":   s.issue(user),
+				"comptage": s.issue(user),
+			})
+			return
+		}
+	}
+
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+func (s *Server) planning(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.mu.Lock()
+	user, ok := s.tokens[token]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if user.PlanningStatus != 0 {
+		http.Error(w, http.StatusText(user.PlanningStatus), user.PlanningStatus)
+		return
+	}
+
+	data, err := fs.ReadFile(s.fixtures, user.Planning)
+	if err != nil {
+		http.Error(w, "fixture not found", http.StatusInternalServerError)
+		return
+	}
+
+	// Malformed fixtures are served untouched to exercise schema errors
+	var events []map[string]any
+	if err := json.Unmarshal(data, &events); err != nil {
+		writeRaw(w, r, data)
+		return
+	}
+
+	// Move the fixtures to the current weeks so the default window of the application sees them
+	if !s.FixedDates {
+		rebase(events, time.Now())
+	}
+
+	// Only keep the events of the requested days, like mycpe does
+	from := r.URL.Query().Get("date_debut")
+	to := r.URL.Query().Get("date_fin")
+	selected := make([]map[string]any, 0, len(events))
+	for _, event := range events {
+		start, _ := event["date_debut"].(string)
+		if len(start) >= 10 && (from == "" || start[:10] >= from) && (to == "" || start[:10] <= to) {
+			selected = append(selected, event)
+		}
+	}
+
+	writeJSON(w, r, selected)
+}
+
+// dateLayout is the date prefix of the mycpe datetimes
+const dateLayout = "2006-01-02"
+
+// dateFields are the event fields holding dates
+var dateFields = []string{"date_debut", "date_fin", "date_debut_multijours", "date_fin_multijours"}
+
+// rebase shifts the dates of events by whole weeks so the first one falls in the week of now,
+// keeping weekdays and times intact
+func rebase(events []map[string]any, now time.Time) {
+	var first time.Time
+	for _, event := range events {
+		if start, ok := fixtureDate(event["date_debut"]); ok && (first.IsZero() || start.Before(first)) {
+			first = start
+		}
+	}
+	if first.IsZero() {
+		return
+	}
+
+	weeks := int(monday(now).Sub(monday(first)).Hours() / 24 / 7)
+	for _, event := range events {
+		for _, field := range dateFields {
+			value, _ := event[field].(string)
+			if len(value) < len(dateLayout) {
+				continue
+			}
+			date, err := time.Parse(dateLayout, value[:len(dateLayout)])
+			if err != nil {
+				continue
+			}
+			event[field] = date.AddDate(0, 0, 7*weeks).Format(dateLayout) + value[len(dateLayout):]
+		}
+	}
+}
+
+func fixtureDate(value any) (time.Time, bool) {
+	s, _ := value.(string)
+	if len(s) < len(dateLayout) {
+		return time.Time{}, false
+	}
+	date, err := time.Parse(dateLayout, s[:len(dateLayout)])
+	return date, err == nil
+}
+
+// monday returns the Monday starting the week of t, at midnight UTC
+func monday(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// issue creates a JWT shaped token carrying the same claims as the real ones
+func (s *Server) issue(user User) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+	claims, _ := json.Marshal(map[string]any{
+		"cree":  time.Now().UnixMilli(),
+		"roles": []string{"ROLE_apprenant"},
+		"id":    user.ID,
+	})
+	signature := make([]byte, 32)
+	rand.Read(signature)
+
+	token := header + "." + base64.RawURLEncoding.EncodeToString(claims) + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	s.mu.Lock()
+	s.tokens[token] = user
+	s.mu.Unlock()
+	return token
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeRaw(w, r, data)
+}
+
+// writeRaw writes a JSON body, gzip encoded when the client accepts it like mycpe
+func writeRaw(w http.ResponseWriter, r *http.Request, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	gz.Write(data)
+}
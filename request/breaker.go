@@ -0,0 +1,136 @@
+package request
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"cpe/calendar/logger"
+	"cpe/calendar/metrics"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker stops calling mycpe after too many consecutive availability failures.
+// Once the cooldown is over a single trial call is let through: its success closes
+// the breaker, its failure opens it again.
+// A 429 does not count as a failure, it pauses every call until its Retry-After instead.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	trial     bool
+	// pausedUntil is when mycpe stops rate limiting us
+	pausedUntil time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// paused returns how long calls remain paused by a 429, zero when they are not
+func (b *breaker) paused(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Before(b.pausedUntil) {
+		return b.pausedUntil.Sub(now)
+	}
+	return 0
+}
+
+// allow reports whether a call may be attempted
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		b.trial = true
+		return true
+	case breakerHalfOpen:
+		// Only one trial call at a time
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of an allowed call
+func (b *breaker) record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+
+	// A caller going away says nothing about mycpe
+	if Kind(err) == KindCanceled {
+		return
+	}
+
+	// mycpe is up but asked to be left alone, wait for as long as it said
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.Kind == KindRateLimited {
+		if until := now.Add(upstreamErr.RetryAfter); until.After(b.pausedUntil) {
+			b.pausedUntil = until
+		}
+		return
+	}
+
+	// Only availability problems count, bad credentials do not mean mycpe is down
+	if !errors.As(err, &upstreamErr) || !upstreamErr.Unavailable() {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = now
+		b.setState(breakerOpen)
+	}
+}
+
+// setState changes the state, the caller must hold the lock
+func (b *breaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+
+	logger.Log.Warn().
+		Str("from", b.state.String()).
+		Str("to", state.String()).
+		Int("failures", b.failures).
+		Msg("Circuit breaker state changed")
+
+	b.state = state
+	metrics.CircuitBreakerState.Set(float64(state))
+}
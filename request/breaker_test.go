@@ -0,0 +1,125 @@
+package request
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	m := newMock(t)
+	c := NewClient(Config{BaseURL: m.URL, MaxAttempts: 1, BreakerThreshold: 3, BreakerCooldown: 50 * time.Millisecond})
+	now := time.Now()
+
+	// broken@cpe.fr logs in but mon_planning answers 502
+	for i := range 3 {
+		if _, err := c.FetchData(now, now, "broken@cpe.fr", "password"); Kind(err) != KindServer {
+			t.Fatalf("call %d: got %v, want a server error", i+1, err)
+		}
+	}
+	if c.breaker.state != breakerOpen {
+		t.Fatalf("breaker is %s after the threshold, want open", c.breaker.state)
+	}
+
+	// While open mycpe is not called at all, not even to log in
+	calls := m.counts()
+	if _, err := c.FetchData(now, now, "student@cpe.fr", "password"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, want %v", err, ErrCircuitOpen)
+	}
+	if m.counts() != calls {
+		t.Errorf("got %s while open, want %s", m.counts(), calls)
+	}
+
+	// After the cooldown a failing trial opens it again
+	time.Sleep(60 * time.Millisecond)
+	if _, err := c.FetchData(now, now, "broken@cpe.fr", "password"); Kind(err) != KindServer {
+		t.Fatalf("trial: got %v, want a server error", err)
+	}
+	if c.breaker.state != breakerOpen {
+		t.Fatalf("breaker is %s after a failed trial, want open", c.breaker.state)
+	}
+	if _, err := c.FetchData(now, now, "student@cpe.fr", "password"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v after a failed trial, want %v", err, ErrCircuitOpen)
+	}
+
+	// A successful trial closes it
+	time.Sleep(60 * time.Millisecond)
+	if _, err := c.FetchData(now, now, "student@cpe.fr", "password"); err != nil {
+		t.Fatalf("trial: %v", err)
+	}
+	if c.breaker.state != breakerClosed || c.breaker.failures != 0 {
+		t.Errorf("breaker is %s with %d failures after a successful trial, want closed", c.breaker.state, c.breaker.failures)
+	}
+}
+
+func TestBreakerHalfOpenAllowsOneTrial(t *testing.T) {
+	b := newBreaker(1, time.Minute)
+	now := time.Now()
+
+	b.record(&UpstreamError{Kind: KindServer, StatusCode: http.StatusBadGateway}, now)
+	if b.allow(now.Add(time.Second)) {
+		t.Fatal("call allowed during the cooldown")
+	}
+
+	later := now.Add(time.Minute)
+	if !b.allow(later) {
+		t.Fatal("trial refused after the cooldown")
+	}
+	if b.state != breakerHalfOpen {
+		t.Errorf("breaker is %s during the trial, want half-open", b.state)
+	}
+	if b.allow(later) {
+		t.Error("second call allowed while the trial runs")
+	}
+}
+
+func TestBreakerIgnoresClientErrors(t *testing.T) {
+	b := newBreaker(2, time.Minute)
+	now := time.Now()
+
+	// Bad credentials or a canceled caller say nothing about mycpe being down
+	for _, err := range []error{
+		&UpstreamError{Kind: KindServer, StatusCode: http.StatusBadGateway},
+		&UpstreamError{Kind: KindUnauthorized, StatusCode: http.StatusUnauthorized},
+		&UpstreamError{Kind: KindServer, StatusCode: http.StatusBadGateway},
+		&UpstreamError{Kind: KindCanceled},
+		&UpstreamError{Kind: KindClient, StatusCode: http.StatusForbidden},
+	} {
+		b.record(err, now)
+	}
+	if b.state != breakerClosed || b.failures != 0 {
+		t.Errorf("breaker is %s with %d failures, want closed", b.state, b.failures)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		username      string
+		status        int
+		wantKind      ErrorKind
+		wantPlannings int32
+	}{
+		{name: "server error", username: "broken@cpe.fr", wantKind: KindServer, wantPlannings: DefaultMaxAttempts},
+		{name: "client error", username: "student@cpe.fr", status: http.StatusForbidden, wantKind: KindClient, wantPlannings: 1},
+		{name: "malformed payload", username: "schema@cpe.fr", wantKind: KindMalformed, wantPlannings: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMock(t)
+			m.planningStatus.Store(int32(tt.status))
+			c := NewClient(Config{BaseURL: m.URL})
+
+			if _, err := c.FetchData(now, now, tt.username, "password"); Kind(err) != tt.wantKind {
+				t.Fatalf("got %v, want a %s error", err, tt.wantKind)
+			}
+			if m.plannings.Load() != tt.wantPlannings {
+				t.Errorf("got %s, want %d plannings", m.counts(), tt.wantPlannings)
+			}
+		})
+	}
+}
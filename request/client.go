@@ -0,0 +1,129 @@
+package request
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"cpe/calendar/types"
+)
+
+const (
+	// DefaultBaseURL is the mycpe host the mobile application talks to
+	DefaultBaseURL = "https://mycpe.cpe.fr"
+	// DefaultUserAgent mimics the Android application
+	DefaultUserAgent = "Dalvik/2.1.0 (Linux; U; Android 15; sdk_gphone64_x86_64 Build/AE3A.240806.005)"
+	// DefaultTimeout bounds every upstream call
+	DefaultTimeout = 30 * time.Second
+	// DefaultLoginTimeout bounds a login call
+	DefaultLoginTimeout = 10 * time.Second
+	// DefaultPlanningTimeout bounds one attempt at fetching the planning
+	DefaultPlanningTimeout = 20 * time.Second
+	// DefaultBreakerThreshold is the number of consecutive failures opening the circuit breaker
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long mycpe is left alone once the breaker opened
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// Config holds the settings of a Client, zero values fall back to the defaults
+type Config struct {
+	BaseURL   string
+	UserAgent string
+	Timeout   time.Duration
+	// LoginTimeout bounds a login call
+	LoginTimeout time.Duration
+	// PlanningTimeout bounds one attempt at fetching the planning
+	PlanningTimeout time.Duration
+	// TokenLifetime is how long a token is reused after its creation
+	TokenLifetime time.Duration
+	// MaxAttempts is the number of tries of idempotent calls failing with a network or server error, timeouts are not retried
+	MaxAttempts int
+	// BreakerThreshold is the number of consecutive failures opening the circuit breaker
+	BreakerThreshold int
+	// BreakerCooldown is how long the circuit breaker stays open before a trial call
+	BreakerCooldown time.Duration
+	// OmitRequestID stops forwarding the X-Request-ID header to mycpe
+	OmitRequestID bool
+}
+
+// Client talks to the mycpe mobile API
+type Client struct {
+	BaseURL         string
+	HTTPClient      *http.Client
+	UserAgent       string
+	Timeout         time.Duration
+	LoginTimeout    time.Duration
+	PlanningTimeout time.Duration
+	MaxAttempts     int
+	OmitRequestID   bool
+	tokens          *tokenCache
+	breaker         *breaker
+}
+
+// DefaultClient is used by the package-level functions
+var DefaultClient = NewClient(Config{})
+
+// NewClient creates a client from cfg
+func NewClient(cfg Config) *Client {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultBaseURL
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = DefaultUserAgent
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.LoginTimeout <= 0 {
+		cfg.LoginTimeout = DefaultLoginTimeout
+	}
+	if cfg.PlanningTimeout <= 0 {
+		cfg.PlanningTimeout = DefaultPlanningTimeout
+	}
+	if cfg.TokenLifetime <= 0 {
+		cfg.TokenLifetime = DefaultTokenLifetime
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.BreakerThreshold <= 0 {
+		cfg.BreakerThreshold = DefaultBreakerThreshold
+	}
+	if cfg.BreakerCooldown <= 0 {
+		cfg.BreakerCooldown = DefaultBreakerCooldown
+	}
+
+	return &Client{
+		BaseURL:         strings.TrimRight(cfg.BaseURL, "/"),
+		HTTPClient:      &http.Client{Timeout: cfg.Timeout},
+		UserAgent:       cfg.UserAgent,
+		Timeout:         cfg.Timeout,
+		LoginTimeout:    cfg.LoginTimeout,
+		PlanningTimeout: cfg.PlanningTimeout,
+		MaxAttempts:     cfg.MaxAttempts,
+		OmitRequestID:   cfg.OmitRequestID,
+		tokens:          newTokenCache(cfg.TokenLifetime),
+		breaker:         newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+	}
+}
+
+// FetchData logs in and fetches the planning with the default client
+func FetchData(start, end time.Time, username, password string) ([]types.Event, error) {
+	return DefaultClient.FetchData(start, end, username, password)
+}
+
+// FetchDataContext is FetchData bounded by ctx
+func FetchDataContext(ctx context.Context, start, end time.Time, username, password string) ([]types.Event, error) {
+	return DefaultClient.FetchDataContext(ctx, start, end, username, password)
+}
+
+// Login authenticates against mycpe with the default client
+func Login(username, password string) (types.TokenResponse, error) {
+	return DefaultClient.Login(username, password)
+}
+
+// LoginContext is Login bounded by ctx
+func LoginContext(ctx context.Context, username, password string) (types.TokenResponse, error) {
+	return DefaultClient.LoginContext(ctx, username, password)
+}
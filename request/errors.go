@@ -0,0 +1,172 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultRateLimitPause is how long mycpe is left alone after a 429 without a usable Retry-After
+	DefaultRateLimitPause = time.Minute
+	// maxRateLimitPause caps the Retry-After announced by mycpe
+	maxRateLimitPause = time.Hour
+)
+
+// ErrorKind classifies upstream failures
+type ErrorKind string
+
+const (
+	// KindNetwork is a connection, TLS or read failure
+	KindNetwork ErrorKind = "network"
+	// KindServer is a 5xx answer
+	KindServer ErrorKind = "server"
+	// KindUnauthorized is a 401, the credentials or the token were rejected
+	KindUnauthorized ErrorKind = "unauthorized"
+	// KindRateLimited is a 429
+	KindRateLimited ErrorKind = "rate_limited"
+	// KindClient is any other unexpected status code
+	KindClient ErrorKind = "client"
+	// KindMalformed is a body that does not match the expected JSON schema
+	KindMalformed ErrorKind = "malformed"
+	// KindCircuitOpen is returned without calling upstream while the circuit breaker is open
+	KindCircuitOpen ErrorKind = "circuit_open"
+	// KindTimeout is a call that did not complete before its deadline
+	KindTimeout ErrorKind = "timeout"
+	// KindCanceled is a call abandoned because the caller went away
+	KindCanceled ErrorKind = "canceled"
+)
+
+// ErrCircuitOpen is returned while mycpe is considered down
+var ErrCircuitOpen = errors.New("circuit breaker open, mycpe is unavailable")
+
+// ErrRateLimited is returned without calling upstream until the Retry-After of a 429 is over
+var ErrRateLimited = errors.New("mycpe is rate limiting requests")
+
+// UpstreamError is returned by every call to mycpe that fails
+type UpstreamError struct {
+	Kind       ErrorKind
+	StatusCode int
+	// RetryAfter is how long mycpe asked to be left alone, set on rate limited calls
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *UpstreamError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s error: received non-200 response: %d", e.Kind, e.StatusCode)
+	}
+	return fmt.Sprintf("%s error: %v", e.Kind, e.Err)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// Unauthorized reports whether mycpe rejected the credentials or the token
+func (e *UpstreamError) Unauthorized() bool {
+	return e.Kind == KindUnauthorized
+}
+
+// Retryable reports whether the same call may succeed if tried again.
+// A rate limited call is not: trying again before its Retry-After only makes it worse.
+// Neither is a timeout: a hung mycpe would hold the request for every attempt's timeout.
+func (e *UpstreamError) Retryable() bool {
+	return e.Kind == KindNetwork || e.Kind == KindServer
+}
+
+// Unavailable reports whether the failure suggests mycpe is down, which the circuit breaker counts
+func (e *UpstreamError) Unavailable() bool {
+	return e.Retryable() || e.Kind == KindTimeout
+}
+
+// Timeout reports whether the call ran out of time, so callers can answer 504
+func (e *UpstreamError) Timeout() bool {
+	return e.Kind == KindTimeout
+}
+
+// statusError classifies a non-200 status code
+func statusError(code int) *UpstreamError {
+	kind := KindClient
+	switch {
+	case code == http.StatusUnauthorized:
+		kind = KindUnauthorized
+	case code == http.StatusTooManyRequests:
+		kind = KindRateLimited
+	case code >= 500:
+		kind = KindServer
+	}
+	return &UpstreamError{Kind: kind, StatusCode: code}
+}
+
+// responseError classifies a non-200 response, keeping the Retry-After of a 429
+func responseError(resp *http.Response, now time.Time) *UpstreamError {
+	err := statusError(resp.StatusCode)
+	if err.Kind == KindRateLimited {
+		err.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	}
+	return err
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date,
+// falling back to DefaultRateLimitPause when it is missing or unusable
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = date.Sub(now)
+	}
+
+	switch {
+	case delay <= 0:
+		return DefaultRateLimitPause
+	case delay > maxRateLimitPause:
+		return maxRateLimitPause
+	default:
+		return delay
+	}
+}
+
+// transportError classifies a failure to send the request or read the response
+func transportError(err error) *UpstreamError {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &UpstreamError{Kind: KindTimeout, Err: err}
+	case errors.Is(err, context.Canceled):
+		return &UpstreamError{Kind: KindCanceled, Err: err}
+	default:
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return &UpstreamError{Kind: KindTimeout, Err: err}
+		}
+		return &UpstreamError{Kind: KindNetwork, Err: err}
+	}
+}
+
+// IsTimeout reports whether err is an upstream call that ran out of time
+func IsTimeout(err error) bool {
+	return Kind(err) == KindTimeout || errors.Is(err, context.DeadlineExceeded)
+}
+
+// RetryAfter returns how long mycpe asked to be left alone, zero when err is not a rate limited call
+func RetryAfter(err error) time.Duration {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) && upstreamErr.Kind == KindRateLimited {
+		return upstreamErr.RetryAfter
+	}
+	return 0
+}
+
+// Kind returns the classification of err, or an empty kind when it is not an upstream error
+func Kind(err error) ErrorKind {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Kind
+	}
+	return ""
+}
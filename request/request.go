@@ -3,56 +3,117 @@ package request
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"cpe/calendar/logger"
 	"cpe/calendar/types"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
 	"time"
-	"cpe/calendar/logger"
 )
 
-func FetchData(start, end, username, password string) ([]types.Event, error) {
+// dateLayout is the day format of the mon_planning date_debut/date_fin parameters
+const dateLayout = "2006-01-02"
+
+// FetchData logs in and fetches the planning between the start and end days, both included
+func (c *Client) FetchData(start, end time.Time, username, password string) ([]types.Event, error) {
+	return c.FetchDataContext(context.Background(), start, end, username, password)
+}
+
+// FetchDataContext is FetchData bounded by ctx, each upstream call also gets its own timeout
+func (c *Client) FetchDataContext(ctx context.Context, start, end time.Time, username, password string) ([]types.Event, error) {
 	// Log the operation with context about the start and end times
-	logger.Log.Info().
-		Str("username", username).
-		Str("start", start).
-		Str("end", end).
+	logger.Ctx(ctx).Info().
+		Str("user", logger.HashUser(username)).
+		Str("start", start.Format(dateLayout)).
+		Str("end", end.Format(dateLayout)).
 		Msg("Fetching data from CPE calendar")
 
-	token, err := Login(username, password)
-	if err != nil {
-		logger.Log.Error().
-			Str("username", username).
-			Err(err).
-			Msg("Failed to login")
-		return nil, err
+	key := tokenKey(username, password)
+	token, cached := c.tokens.get(key, time.Now())
+	if cached {
+		logger.Ctx(ctx).Debug().
+			Str("user", logger.HashUser(username)).
+			Msg("Reusing cached token")
+	} else {
+		var err error
+		token, err = c.login(ctx, key, username, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := c.getCalendar(ctx, token, start, end)
+
+	// A cached token may have been revoked upstream: log in again once
+	if cached && Kind(err) == KindUnauthorized {
+		logger.Ctx(ctx).Info().
+			Str("user", logger.HashUser(username)).
+			Msg("Cached token rejected, logging in again")
+		c.tokens.invalidate(key)
+
+		token, err = c.login(ctx, key, username, password)
+		if err != nil {
+			return nil, err
+		}
+		body, err = c.getCalendar(ctx, token, start, end)
 	}
 
-	body, err := getCalendar(token, start, end)
 	if err != nil {
-		logger.Log.Error().
-			Str("username", username).
+		logger.Ctx(ctx).Error().
+			Str("user", logger.HashUser(username)).
 			Err(err).
 			Msg("Failed to fetch calendar data")
 		return nil, err
 	}
 
-	logger.Log.Info().
-		Str("username", username).
+	logger.Ctx(ctx).Info().
+		Str("user", logger.HashUser(username)).
 		Msg("Data fetched successfully")
 	return body, nil
 }
 
-func Login(username, password string) (types.TokenResponse, error) {
+// login authenticates and caches the resulting token under key
+func (c *Client) login(ctx context.Context, key, username, password string) (types.TokenResponse, error) {
+	token, err := c.LoginContext(ctx, username, password)
+	if err != nil {
+		logger.Ctx(ctx).Error().
+			Str("user", logger.HashUser(username)).
+			Err(err).
+			Msg("Failed to login")
+		return types.TokenResponse{}, err
+	}
+
+	c.tokens.put(key, token, time.Now())
+	return token, nil
+}
+
+// Login authenticates against mycpe and returns the session tokens
+func (c *Client) Login(username, password string) (types.TokenResponse, error) {
+	return c.LoginContext(context.Background(), username, password)
+}
+
+// LoginContext is Login bounded by ctx and the login timeout.
+// Login is not retried: a failure is reported to the caller right away.
+func (c *Client) LoginContext(ctx context.Context, username, password string) (types.TokenResponse, error) {
+	var token types.TokenResponse
+	err := c.call(ctx, "login", c.LoginTimeout, func(ctx context.Context) error {
+		var err error
+		token, err = c.doLogin(ctx, username, password)
+		return err
+	})
+	return token, err
+}
+
+func (c *Client) doLogin(ctx context.Context, username, password string) (types.TokenResponse, error) {
 	// Log the login request with username context
-	logger.Log.Info().
-		Str("username", username).
+	logger.Ctx(ctx).Info().
+		Str("user", logger.HashUser(username)).
 		Msg("Initiating login request")
 
 	// Prepare the login request
-	urlStr := "https://mycpe.cpe.fr/mobile/login"
+	urlStr := c.BaseURL + "/mobile/login"
 	loginData := map[string]string{
 		"login":    username,
 		"password": password,
@@ -61,111 +122,108 @@ func Login(username, password string) (types.TokenResponse, error) {
 	// Marshal login data to JSON
 	jsonData, err := json.Marshal(loginData)
 	if err != nil {
-		logger.Log.Error().
-			Str("username", username).
+		logger.Ctx(ctx).Error().
+			Str("user", logger.HashUser(username)).
 			Err(err).
 			Msg("Failed to marshal login data")
 		return types.TokenResponse{}, fmt.Errorf("failed to marshal login data: %w", err)
 	}
 
 	// Create the request
-	req, err := http.NewRequest("POST", urlStr, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", urlStr, bytes.NewBuffer(jsonData))
 	if err != nil {
-		logger.Log.Error().
-			Str("username", username).
+		logger.Ctx(ctx).Error().
+			Str("user", logger.HashUser(username)).
 			Err(err).
 			Msg("Failed to create login request")
 		return types.TokenResponse{}, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
-	req.Header.Set("User-Agent", "Dalvik/2.1.0 (Linux; U; Android 15; sdk_gphone64_x86_64 Build/AE3A.240806.005)")
+	req.Header.Set("User-Agent", c.UserAgent)
 	req.Header.Set("Content-Type", "application/json")
+	c.setRequestID(ctx, req)
 
 	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		logger.Log.Error().
-			Str("username", username).
+		logger.Ctx(ctx).Error().
+			Str("user", logger.HashUser(username)).
 			Err(err).
 			Msg("Login request failed")
-		return types.TokenResponse{}, fmt.Errorf("request failed: %w", err)
+		return types.TokenResponse{}, transportError(fmt.Errorf("request failed: %w", err))
 	}
 	defer resp.Body.Close()
 
 	// Check for non-200 status codes
 	if resp.StatusCode != http.StatusOK {
-		logger.Log.Error().
-			Str("username", username).
+		logger.Ctx(ctx).Error().
+			Str("user", logger.HashUser(username)).
 			Int("statusCode", resp.StatusCode).
 			Msg("Received non-200 response for login")
-		return types.TokenResponse{}, fmt.Errorf("received non-200 response: %d", resp.StatusCode)
+		return types.TokenResponse{}, responseError(resp, time.Now())
 	}
 
 	// Read and unmarshal the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		logger.Log.Error().
-			Str("username", username).
+		logger.Ctx(ctx).Error().
+			Str("user", logger.HashUser(username)).
 			Err(err).
 			Msg("Failed to read login response body")
-		return types.TokenResponse{}, fmt.Errorf("failed to read response body: %w", err)
+		return types.TokenResponse{}, transportError(fmt.Errorf("failed to read response body: %w", err))
 	}
 
 	var formattedResp types.TokenResponse
 	if err := json.Unmarshal(body, &formattedResp); err != nil {
-		logger.Log.Error().
-			Str("username", username).
+		logger.Ctx(ctx).Error().
+			Str("user", logger.HashUser(username)).
 			Err(err).
 			Msg("Failed to parse login response JSON")
-		return types.TokenResponse{}, fmt.Errorf("failed to parse JSON: %w", err)
+		return types.TokenResponse{}, &UpstreamError{Kind: KindMalformed, Err: fmt.Errorf("failed to parse JSON: %w", err)}
 	}
 
-	logger.Log.Info().
-		Str("username", username).
+	logger.Ctx(ctx).Info().
+		Str("user", logger.HashUser(username)).
 		Msg("Login successful")
 	return formattedResp, nil
 }
 
-func getCalendar(token types.TokenResponse, startUnix, endUnix string) ([]types.Event, error) {
+// getCalendar fetches the planning, retrying transient failures since the call is idempotent
+func (c *Client) getCalendar(ctx context.Context, token types.TokenResponse, start, end time.Time) ([]types.Event, error) {
+	var events []types.Event
+	err := c.retry(ctx, "mon_planning", c.PlanningTimeout, func(ctx context.Context) error {
+		var err error
+		events, err = c.fetchPlanning(ctx, token, start, end)
+		return err
+	})
+	return events, err
+}
+
+func (c *Client) fetchPlanning(ctx context.Context, token types.TokenResponse, start, end time.Time) ([]types.Event, error) {
+	// Format the days in the "YYYY-MM-DD" form expected by mycpe
+	startDate := start.Format(dateLayout)
+	endDate := end.Format(dateLayout)
+
 	// Log the request to fetch calendar data with the token and time context
-	logger.Log.Info().
-		Str("token", token.Normal).
-		Str("startUnix", startUnix).
-		Str("endUnix", endUnix).
+	logger.Ctx(ctx).Info().
+		Str("token", logger.Fingerprint(token.Normal)).
+		Str("startDate", startDate).
+		Str("endDate", endDate).
 		Msg("Fetching calendar data")
 
 	// Define the base URL and query parameters
-	baseURL := "https://mycpe.cpe.fr/mobile/mon_planning"
-
-	startTime, err := unixToDateTime(startUnix)
-	if err != nil {
-		logger.Log.Error().
-			Str("startUnix", startUnix).
-			Err(err).
-			Msg("Failed to parse start time")
-		return nil, fmt.Errorf("failed to parse start time: %w", err)
-	}
-
-	endTime, err := unixToDateTime(endUnix)
-	if err != nil {
-		logger.Log.Error().
-			Str("endUnix", endUnix).
-			Err(err).
-			Msg("Failed to parse end time")
-		return nil, fmt.Errorf("failed to parse end time: %w", err)
-	}
+	baseURL := c.BaseURL + "/mobile/mon_planning"
 
-	query := fmt.Sprintf("?date_debut=%s&date_fin=%s", startTime, endTime)
-	logger.Log.Debug().
+	query := fmt.Sprintf("?date_debut=%s&date_fin=%s", startDate, endDate)
+	logger.Ctx(ctx).Debug().
 		Str("finalURL", baseURL+query).
 		Msg("Generated final URL")
 
 	// Create the GET request
-	req, err := http.NewRequest("GET", baseURL+query, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+query, nil)
 	if err != nil {
-		logger.Log.Error().
+		logger.Ctx(ctx).Error().
 			Str("finalURL", baseURL+query).
 			Err(err).
 			Msg("Failed to create calendar request")
@@ -173,23 +231,22 @@ func getCalendar(token types.TokenResponse, startUnix, endUnix string) ([]types.
 	}
 
 	// Add headers to match the curl request
-	req.Header.Add("User-Agent", "Dalvik/2.1.0 (Linux; U; Android 15; sdk_gphone64_x86_64 Build/AE3A.240806.005)")
+	req.Header.Add("User-Agent", c.UserAgent)
 	req.Header.Add("Authorization", "Bearer "+token.Normal)
 	req.Header.Add("Accept-Encoding", "gzip")
 	req.Header.Add("Accept-Language", "en-US,en;q=0.5")
 	req.Header.Add("Connection", "Keep-Alive")
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Host", "mycpe.cpe.fr")
+	c.setRequestID(ctx, req)
 
 	// Send the GET request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		logger.Log.Error().
+		logger.Ctx(ctx).Error().
 			Str("finalURL", baseURL+query).
 			Err(err).
 			Msg("Request failed to get calendar data")
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, transportError(fmt.Errorf("request failed: %w", err))
 	}
 	defer resp.Body.Close()
 
@@ -198,66 +255,59 @@ func getCalendar(token types.TokenResponse, startUnix, endUnix string) ([]types.
 	if resp.Header.Get("Content-Encoding") == "gzip" {
 		reader, err = gzip.NewReader(resp.Body)
 		if err != nil {
-			logger.Log.Error().
+			logger.Ctx(ctx).Error().
 				Str("finalURL", baseURL+query).
 				Err(err).
 				Msg("Failed to create gzip reader")
-			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+			return nil, &UpstreamError{Kind: KindNetwork, Err: fmt.Errorf("failed to create gzip reader: %w", err)}
 		}
 		defer reader.(*gzip.Reader).Close()
 	}
 
 	// Check for non-200 status codes
 	if resp.StatusCode != http.StatusOK {
-		logger.Log.Error().
+		logger.Ctx(ctx).Error().
 			Str("finalURL", baseURL+query).
 			Int("statusCode", resp.StatusCode).
 			Msg("Received non-200 response while fetching calendar")
-		return nil, fmt.Errorf("received non-200 response: %d", resp.StatusCode)
+		return nil, responseError(resp, time.Now())
 	}
 
 	// Read the response body
 	body, err := io.ReadAll(reader)
 	if err != nil {
-		logger.Log.Error().
+		logger.Ctx(ctx).Error().
 			Str("finalURL", baseURL+query).
 			Err(err).
 			Msg("Failed to read calendar response body")
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, transportError(fmt.Errorf("failed to read response body: %w", err))
 	}
 
 	// Parse the JSON response into the events slice
 	var events []types.Event
 	err = json.Unmarshal(body, &events)
 	if err != nil {
-		logger.Log.Error().
+		logger.Ctx(ctx).Error().
 			Str("finalURL", baseURL+query).
 			Err(err).
 			Msg("Failed to parse calendar JSON response")
-		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+		return nil, &UpstreamError{Kind: KindMalformed, Err: fmt.Errorf("failed to parse JSON: %w", err)}
 	}
 
-	logger.Log.Info().
-		Str("token", token.Normal).
-		Str("startUnix", startUnix).
-		Str("endUnix", endUnix).
+	logger.Ctx(ctx).Info().
+		Str("token", logger.Fingerprint(token.Normal)).
+		Str("startDate", startDate).
+		Str("endDate", endDate).
 		Msg("Calendar data fetched successfully")
 	return events, nil
 }
 
-func unixToDateTime(rawTime string) (string, error) {
-	start, err := strconv.ParseInt(rawTime, 10, 64)
-	if err != nil {
-		logger.Log.Error().
-			Str("rawTime", rawTime).
-			Err(err).
-			Msg("Invalid Unix time format")
-		return "", fmt.Errorf("invalid unix time: %v", err)
+// setRequestID forwards the ID of the incoming request so both sides can be correlated
+func (c *Client) setRequestID(ctx context.Context, req *http.Request) {
+	if c.OmitRequestID {
+		return
+	}
+	if id := logger.RequestID(ctx); id != "" {
+		req.Header.Set(logger.RequestIDHeader, id)
 	}
-
-	start = start / 1000
-	dateTime := time.Unix(start, 0)
-
-	// Format the time to match the query format ("YYYY-MM-DD")
-	return dateTime.Format("2006-01-02"), nil
 }
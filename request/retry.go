@@ -0,0 +1,89 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"cpe/calendar/logger"
+	"cpe/calendar/metrics"
+)
+
+const (
+	// DefaultMaxAttempts is the number of tries of an idempotent call
+	DefaultMaxAttempts = 3
+	// retryBaseDelay is the backoff before the second attempt, doubled for every next one
+	retryBaseDelay = 500 * time.Millisecond
+	// retryMaxDelay caps the backoff
+	retryMaxDelay = 5 * time.Second
+)
+
+// call runs fn through the circuit breaker with its own timeout and records its outcome under endpoint
+func (c *Client) call(ctx context.Context, endpoint string, timeout time.Duration, fn func(context.Context) error) error {
+	if wait := c.breaker.paused(time.Now()); wait > 0 {
+		metrics.IncWithExemplar(ctx, metrics.UpstreamRequests.WithLabelValues(endpoint, string(KindRateLimited)))
+		return &UpstreamError{Kind: KindRateLimited, RetryAfter: wait, Err: ErrRateLimited}
+	}
+	if !c.breaker.allow(time.Now()) {
+		metrics.IncWithExemplar(ctx, metrics.UpstreamRequests.WithLabelValues(endpoint, string(KindCircuitOpen)))
+		return &UpstreamError{Kind: KindCircuitOpen, Err: ErrCircuitOpen}
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	err := fn(callCtx)
+	cancel()
+	c.breaker.record(err, time.Now())
+
+	outcome := "success"
+	if kind := Kind(err); kind != "" {
+		outcome = string(kind)
+	} else if err != nil {
+		outcome = "error"
+	}
+	metrics.IncWithExemplar(ctx, metrics.UpstreamRequests.WithLabelValues(endpoint, outcome))
+	return err
+}
+
+// retry runs an idempotent call, trying again with jittered exponential backoff on transient failures
+func (c *Client) retry(ctx context.Context, endpoint string, timeout time.Duration, fn func(context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = c.call(ctx, endpoint, timeout, fn)
+
+		var upstreamErr *UpstreamError
+		if err == nil || !errors.As(err, &upstreamErr) || !upstreamErr.Retryable() || attempt >= c.MaxAttempts {
+			return err
+		}
+
+		// The caller's own deadline is over, another attempt cannot succeed
+		if ctx.Err() != nil {
+			return err
+		}
+
+		delay := backoff(attempt)
+		logger.Ctx(ctx).Warn().
+			Err(err).
+			Str("endpoint", endpoint).
+			Int("attempt", attempt).
+			Dur("delay", delay).
+			Msg("Upstream call failed, retrying")
+		metrics.IncWithExemplar(ctx, metrics.UpstreamRetries.WithLabelValues(endpoint))
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return transportError(ctx.Err())
+		}
+	}
+}
+
+// backoff returns the exponential backoff of the attempt, half of it randomized to spread retries
+func backoff(attempt int) time.Duration {
+	ceiling := retryBaseDelay << (attempt - 1)
+	if ceiling > retryMaxDelay || ceiling <= 0 {
+		ceiling = retryMaxDelay
+	}
+	half := ceiling / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
@@ -0,0 +1,98 @@
+package request
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedCallsAreNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	c := NewClient(Config{BaseURL: upstream.URL, BreakerThreshold: 1})
+	_, err := c.Login("student@cpe.fr", "password")
+	if Kind(err) != KindRateLimited {
+		t.Fatalf("got %v, want a rate limited error", err)
+	}
+	if got := RetryAfter(err); got != 120*time.Second {
+		t.Errorf("retry after %s, want the 2m announced by mycpe", got)
+	}
+
+	// The pause holds every call back without counting as a breaker failure
+	_, err = c.Login("student@cpe.fr", "password")
+	if Kind(err) != KindRateLimited {
+		t.Fatalf("got %v during the pause, want a rate limited error", err)
+	}
+	if got := RetryAfter(err); got <= 0 || got > 120*time.Second {
+		t.Errorf("retry after %s during the pause, want what is left of it", got)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("mycpe called %d times, want 1", n)
+	}
+	if c.breaker.state != breakerClosed {
+		t.Errorf("breaker is %s, a 429 must not open it", c.breaker.state)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 2, 17, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "30", want: 30 * time.Second},
+		{value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second},
+		{value: "", want: DefaultRateLimitPause},
+		{value: "soon", want: DefaultRateLimitPause},
+		{value: "0", want: DefaultRateLimitPause},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), want: DefaultRateLimitPause},
+		{value: "86400", want: maxRateLimitPause},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestTimeoutsAreNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/mobile/login" {
+			w.Write([]byte(`{"normal":"token","comptage":"token"}`))
+			return
+		}
+		// A hung mon_planning
+		calls.Add(1)
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	c := NewClient(Config{BaseURL: upstream.URL, PlanningTimeout: 50 * time.Millisecond, BreakerThreshold: 1})
+	start := time.Now()
+	_, err := c.FetchData(start, start, "student@cpe.fr", "password")
+	if Kind(err) != KindTimeout {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+
+	// The request is held for a single planning timeout, not one per attempt
+	if n := calls.Load(); n != 1 {
+		t.Errorf("mon_planning called %d times, want 1", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s", elapsed)
+	}
+	if c.breaker.state != breakerOpen {
+		t.Errorf("breaker is %s, a timeout must count as a failure", c.breaker.state)
+	}
+}
@@ -0,0 +1,121 @@
+package request
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cpe/calendar/types"
+)
+
+// DefaultTokenLifetime is how long a mycpe token is assumed valid after its creation
+const DefaultTokenLifetime = time.Hour
+
+// tokenRefreshMargin renews tokens slightly before they are expected to expire
+const tokenRefreshMargin = 5 * time.Minute
+
+// Claims is the payload of the mycpe JWT
+type Claims struct {
+	// Cree is the creation time in Unix milliseconds
+	Cree  int64    `json:"cree"`
+	Roles []string `json:"roles"`
+	ID    int64    `json:"id"`
+}
+
+// IssuedAt returns the creation time of the token
+func (c Claims) IssuedAt() time.Time {
+	return time.UnixMilli(c.Cree)
+}
+
+// ParseClaims decodes the payload of a JWT without verifying its signature,
+// mycpe is trusted and the token is only used to know when to renew it
+func ParseClaims(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("malformed token: %d parts", len(parts))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return Claims{}, fmt.Errorf("failed to decode token payload: %w", err)
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Claims{}, fmt.Errorf("failed to parse token payload: %w", err)
+	}
+	return claims, nil
+}
+
+// tokenCache keeps the tokens of recently seen users. Entries are keyed by a hash of the
+// credentials so passwords are never stored and a changed password means a new login.
+type tokenCache struct {
+	lifetime time.Duration
+	mu       sync.Mutex
+	tokens   map[string]cachedToken
+}
+
+type cachedToken struct {
+	token     types.TokenResponse
+	expiresAt time.Time
+}
+
+func newTokenCache(lifetime time.Duration) *tokenCache {
+	return &tokenCache{
+		lifetime: lifetime,
+		tokens:   map[string]cachedToken{},
+	}
+}
+
+// tokenKey hashes credentials into a token cache key
+func tokenKey(username, password string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the cached token of key when it is not about to expire
+func (tc *tokenCache) get(key string, now time.Time) (types.TokenResponse, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	cached, ok := tc.tokens[key]
+	if !ok {
+		return types.TokenResponse{}, false
+	}
+	if now.After(cached.expiresAt.Add(-tokenRefreshMargin)) {
+		delete(tc.tokens, key)
+		return types.TokenResponse{}, false
+	}
+	return cached.token, true
+}
+
+// put stores a token, its expiry is derived from the creation time in its claims
+func (tc *tokenCache) put(key string, token types.TokenResponse, now time.Time) {
+	issuedAt := now
+	if claims, err := ParseClaims(token.Normal); err == nil && claims.Cree > 0 {
+		issuedAt = claims.IssuedAt()
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	// Drop expired entries so users who stopped polling do not accumulate
+	for k, cached := range tc.tokens {
+		if now.After(cached.expiresAt) {
+			delete(tc.tokens, k)
+		}
+	}
+	tc.tokens[key] = cachedToken{token: token, expiresAt: issuedAt.Add(tc.lifetime)}
+}
+
+// invalidate forgets the token of key, e.g. after mycpe rejected it
+func (tc *tokenCache) invalidate(key string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	delete(tc.tokens, key)
+}
@@ -0,0 +1,201 @@
+package request
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cpe/calendar/mockcpe"
+	"cpe/calendar/types"
+)
+
+// mock serves the mycpe fixtures and counts the calls of each endpoint
+type mock struct {
+	*mockcpe.Server
+	URL       string
+	logins    atomic.Int32
+	plannings atomic.Int32
+	// planningStatus forces the status code of every mon_planning call when set
+	planningStatus atomic.Int32
+}
+
+func newMock(t *testing.T) *mock {
+	t.Helper()
+	server, err := mockcpe.NewServer(mockcpe.Fixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mock{Server: server}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mobile/login":
+			m.logins.Add(1)
+		case "/mobile/mon_planning":
+			m.plannings.Add(1)
+			if status := int(m.planningStatus.Load()); status != 0 {
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+		}
+		m.ServeHTTP(w, r)
+	}))
+	t.Cleanup(upstream.Close)
+	m.URL = upstream.URL
+	return m
+}
+
+// counts reports the logins and mon_planning calls received so far
+func (m *mock) counts() string {
+	return fmt.Sprintf("%d logins, %d plannings", m.logins.Load(), m.plannings.Load())
+}
+
+// jwt returns a token whose claims were created at issuedAt
+func jwt(issuedAt time.Time) types.TokenResponse {
+	claims := fmt.Sprintf(`{"cree":%d,"roles":["ROLE_apprenant"],"id":1}`, issuedAt.UnixMilli())
+	return types.TokenResponse{Normal: "header." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"}
+}
+
+func TestTokenCache(t *testing.T) {
+	tc := newTokenCache(time.Hour)
+	issuedAt := time.Date(2025, 2, 17, 12, 0, 0, 0, time.UTC)
+
+	// The expiry follows the creation time in the claims, not when the token was stored
+	tc.put("key", jwt(issuedAt), issuedAt.Add(10*time.Minute))
+	if _, ok := tc.get("key", issuedAt.Add(54*time.Minute)); !ok {
+		t.Error("token dropped before its lifetime")
+	}
+	if _, ok := tc.get("key", issuedAt.Add(56*time.Minute)); ok {
+		t.Error("token kept within the refresh margin of its expiry")
+	}
+	if _, ok := tc.get("key", issuedAt); ok {
+		t.Error("expired token not forgotten")
+	}
+
+	// Tokens without claims expire a lifetime after being stored
+	tc.put("opaque", types.TokenResponse{Normal: "opaque"}, issuedAt)
+	if _, ok := tc.get("opaque", issuedAt.Add(54*time.Minute)); !ok {
+		t.Error("opaque token dropped before its lifetime")
+	}
+	tc.invalidate("opaque")
+	if _, ok := tc.get("opaque", issuedAt); ok {
+		t.Error("invalidated token still cached")
+	}
+
+	// Storing a token sweeps the expired ones
+	tc.put("old", jwt(issuedAt), issuedAt)
+	tc.put("new", jwt(issuedAt.Add(2*time.Hour)), issuedAt.Add(2*time.Hour))
+	if len(tc.tokens) != 1 {
+		t.Errorf("%d tokens cached, want only the new one", len(tc.tokens))
+	}
+}
+
+func TestFetchDataReusesTokens(t *testing.T) {
+	m := newMock(t)
+	c := NewClient(Config{BaseURL: m.URL})
+	now := time.Now()
+
+	for range 2 {
+		if _, err := c.FetchData(now, now, "student@cpe.fr", "password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.logins.Load() != 1 || m.plannings.Load() != 2 {
+		t.Errorf("got %s, want the token reused", m.counts())
+	}
+
+	// Another account gets its own token
+	if _, err := c.FetchData(now, now, "empty@cpe.fr", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if m.logins.Load() != 2 {
+		t.Errorf("got %s, want a login per account", m.counts())
+	}
+}
+
+func TestFetchDataLogsInAfterTheTokenLifetime(t *testing.T) {
+	m := newMock(t)
+	c := NewClient(Config{BaseURL: m.URL, TokenLifetime: 30 * time.Minute})
+	now := time.Now()
+
+	if _, err := c.FetchData(now, now, "student@cpe.fr", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Age the cached token past the configured lifetime
+	key := tokenKey("student@cpe.fr", "password")
+	c.tokens.mu.Lock()
+	cached := c.tokens.tokens[key]
+	cached.expiresAt = cached.expiresAt.Add(-30 * time.Minute)
+	c.tokens.tokens[key] = cached
+	c.tokens.mu.Unlock()
+
+	if _, err := c.FetchData(now, now, "student@cpe.fr", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if m.logins.Load() != 2 {
+		t.Errorf("got %s, want a new login once the token expired", m.counts())
+	}
+}
+
+func TestFetchDataLogsInAgainWhenTheTokenIsRevoked(t *testing.T) {
+	m := newMock(t)
+	c := NewClient(Config{BaseURL: m.URL})
+	now := time.Now()
+
+	if _, err := c.FetchData(now, now, "student@cpe.fr", "password"); err != nil {
+		t.Fatal(err)
+	}
+	m.Revoke()
+
+	events, err := c.FetchData(now, now, "student@cpe.fr", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 {
+		t.Error("no events after logging in again")
+	}
+	if m.logins.Load() != 2 || m.plannings.Load() != 3 {
+		t.Errorf("got %s, want the rejected call made again after a login", m.counts())
+	}
+
+	// The new token replaced the rejected one
+	if _, err := c.FetchData(now, now, "student@cpe.fr", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if m.logins.Load() != 2 {
+		t.Errorf("got %s, want the new token reused", m.counts())
+	}
+}
+
+func TestFetchDataLogsInAgainOnce(t *testing.T) {
+	m := newMock(t)
+	c := NewClient(Config{BaseURL: m.URL})
+	now := time.Now()
+
+	if _, err := c.FetchData(now, now, "student@cpe.fr", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	// mycpe rejects every token, even fresh ones
+	m.planningStatus.Store(http.StatusUnauthorized)
+	if _, err := c.FetchData(now, now, "student@cpe.fr", "password"); Kind(err) != KindUnauthorized {
+		t.Fatalf("got %v, want an unauthorized error", err)
+	}
+	if m.logins.Load() != 2 || m.plannings.Load() != 3 {
+		t.Errorf("got %s, want a single login after the rejected token", m.counts())
+	}
+
+	// A token just obtained is not retried at all
+	c.tokens.invalidate(tokenKey("student@cpe.fr", "password"))
+	if _, err := c.FetchData(now, now, "student@cpe.fr", "password"); Kind(err) != KindUnauthorized {
+		t.Fatalf("got %v, want an unauthorized error", err)
+	}
+	if m.logins.Load() != 3 || m.plannings.Load() != 4 {
+		t.Errorf("got %s, want no second login for a fresh token", m.counts())
+	}
+}
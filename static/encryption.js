@@ -90,3 +90,65 @@ async function encryptMessage(message) {
     // Convert the ciphertext to Base64
     return arrayBufferToBase64(ciphertext);
 }
+
+/*
+Convert an ArrayBuffer into URL-safe Base64 without padding
+*/
+function arrayBufferToBase64Url(buffer) {
+    return arrayBufferToBase64(buffer)
+        .replace(/\+/g, '-')
+        .replace(/\//g, '_')
+        .replace(/=+$/, '');
+}
+
+/*
+  Seal the message in a version 2 envelope, the format parsed by decrypt/envelope.go:
+  "v2." then the URL-safe base64 of version (1 byte) | key ID (4 bytes) | RSA-OAEP wrapped AES-256 key | GCM nonce (12 bytes) | ciphertext and tag.
+  The message is encrypted with a fresh AES-GCM key so its length is not limited by the RSA key size.
+*/
+async function encryptEnvelope(message, keyId) {
+    // The version and key ID are authenticated along with the ciphertext
+    const header = new Uint8Array(5);
+    header[0] = 2;
+    for (let i = 0; i < 4; i++) {
+        header[i + 1] = parseInt(keyId.substr(i * 2, 2), 16);
+    }
+
+    const aesKey = await window.crypto.subtle.generateKey(
+        {
+            name: "AES-GCM",
+            length: 256
+        },
+        true,
+        ["encrypt"]
+    );
+    const nonce = window.crypto.getRandomValues(new Uint8Array(12));
+    const ciphertext = await window.crypto.subtle.encrypt(
+        {
+            name: "AES-GCM",
+            iv: nonce,
+            additionalData: header
+        },
+        aesKey,
+        getMessageEncoding(message)
+    );
+
+    // Wrap the AES key with the RSA public key
+    const rawKey = await window.crypto.subtle.exportKey("raw", aesKey);
+    const wrappedKey = await window.crypto.subtle.encrypt(
+        {
+            name: "RSA-OAEP"
+        },
+        encryptionKey,
+        rawKey
+    );
+
+    const envelope = new Uint8Array(header.byteLength + wrappedKey.byteLength + nonce.byteLength + ciphertext.byteLength);
+    let offset = 0;
+    for (const part of [header, new Uint8Array(wrappedKey), nonce, new Uint8Array(ciphertext)]) {
+        envelope.set(part, offset);
+        offset += part.byteLength;
+    }
+
+    return 'v2.' + arrayBufferToBase64Url(envelope);
+}
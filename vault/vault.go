@@ -0,0 +1,369 @@
+// Package vault stores subscription credentials server side, behind opaque tokens.
+// Only a hash of each token is written to disk, and the credentials are encrypted with a key
+// derived from both the vault key and the token, so the files alone reveal nothing.
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cpe/calendar/decrypt"
+)
+
+// KeySize is the size of the vault key, AES-256
+const KeySize = 32
+
+// tokenSize is the number of random bytes of a token, 32 characters once encoded
+const tokenSize = 24
+
+// maxUserAgentSize bounds the user agent kept for a subscription
+const maxUserAgentSize = 200
+
+var (
+	// validToken matches the tokens issued by the vault
+	validToken = regexp.MustCompile(`^[A-Za-z0-9_-]{32}$`)
+	// validID matches the storage IDs of the tokens
+	validID = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// ErrNotFound is returned for unknown or revoked tokens
+var ErrNotFound = errors.New("subscription not found")
+
+// Entry is a stored subscription
+type Entry struct {
+	// ID is the hex SHA-256 of the token, the token itself is never stored
+	ID string `json:"id"`
+	// Subscriber identifies the account, see history.SubscriberKey
+	Subscriber string    `json:"subscriber"`
+	CreatedAt  time.Time `json:"created_at"`
+	// LastAccess and UserAgent describe the last calendar client that fetched the feed
+	LastAccess time.Time `json:"last_access,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// Vault keeps one JSON file per subscription in a directory
+type Vault struct {
+	dir string
+	key []byte
+	mu  sync.Mutex
+}
+
+// Open creates a vault in dir encrypting with key, which must be KeySize bytes
+func Open(dir string, key []byte) (*Vault, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("vault key must be %d bytes, got %d", KeySize, len(key))
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create vault directory: %w", err)
+	}
+	return &Vault{dir: dir, key: key}, nil
+}
+
+// TokenID returns the storage ID of a token, also safe to log
+func TokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Put stores creds for subscriber under a new token
+func (v *Vault) Put(subscriber string, creds decrypt.Credentials, now time.Time) (string, error) {
+	token, entry, err := v.seal(subscriber, creds, now)
+	if err != nil {
+		return "", err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.save(entry); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Get returns the credentials stored under token
+func (v *Vault) Get(token string) (decrypt.Credentials, error) {
+	if !validToken.MatchString(token) {
+		return decrypt.Credentials{}, ErrNotFound
+	}
+
+	v.mu.Lock()
+	entry, err := v.load(TokenID(token))
+	v.mu.Unlock()
+	if err != nil {
+		return decrypt.Credentials{}, err
+	}
+	return v.open(token, entry)
+}
+
+// Touch records an access to the feed of token by a client
+func (v *Vault) Touch(token string, now time.Time, userAgent string) error {
+	if !validToken.MatchString(token) {
+		return ErrNotFound
+	}
+	if len(userAgent) > maxUserAgentSize {
+		userAgent = userAgent[:maxUserAgentSize]
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, err := v.load(TokenID(token))
+	if err != nil {
+		return err
+	}
+	entry.LastAccess = now
+	entry.UserAgent = userAgent
+	return v.save(entry)
+}
+
+// List returns the subscriptions of subscriber, oldest first.
+// Every file is read, which is fine for the few hundred subscriptions of a school.
+func (v *Vault) List(subscriber string) ([]Entry, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.list(subscriber)
+}
+
+// RevokeID deletes the subscription id of subscriber, for owners who no longer have the link
+func (v *Vault) RevokeID(subscriber, id string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, err := v.owned(subscriber, id); err != nil {
+		return err
+	}
+	return v.remove(id)
+}
+
+// RevokeAll deletes every subscription of subscriber and returns how many there were
+func (v *Vault) RevokeAll(subscriber string) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entries, err := v.list(subscriber)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if err := v.remove(entry.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
+
+// Replace moves the subscription id of subscriber to a new token storing creds.
+// Without the old token its credentials cannot be decrypted, so the caller provides them again.
+func (v *Vault) Replace(subscriber, id string, creds decrypt.Credentials, now time.Time) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, err := v.owned(subscriber, id); err != nil {
+		return "", err
+	}
+
+	token, entry, err := v.seal(subscriber, creds, now)
+	if err != nil {
+		return "", err
+	}
+	if err := v.save(entry); err != nil {
+		return "", err
+	}
+	if err := v.remove(id); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// owned loads the entry id when it belongs to subscriber, the caller must hold the lock
+func (v *Vault) owned(subscriber, id string) (Entry, error) {
+	if !validID.MatchString(id) {
+		return Entry{}, ErrNotFound
+	}
+	entry, err := v.load(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	// Someone else's subscription is reported as missing, not as forbidden
+	if entry.Subscriber != subscriber {
+		return Entry{}, ErrNotFound
+	}
+	return entry, nil
+}
+
+// list reads the entries of subscriber, the caller must hold the lock
+func (v *Vault) list(subscriber string) ([]Entry, error) {
+	files, err := os.ReadDir(v.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+
+	entries := []Entry{}
+	for _, file := range files {
+		id, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok || !validID.MatchString(id) {
+			continue
+		}
+		entry, err := v.load(id)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Subscriber == subscriber {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
+
+// seal encrypts creds under a new token
+func (v *Vault) seal(subscriber string, creds decrypt.Credentials, now time.Time) (string, Entry, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", Entry{}, err
+	}
+
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return "", Entry{}, fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+
+	gcm, err := v.cipher(token)
+	if err != nil {
+		return "", Entry{}, err
+	}
+	entry := Entry{
+		ID:         TokenID(token),
+		Subscriber: subscriber,
+		CreatedAt:  now,
+		Nonce:      make([]byte, gcm.NonceSize()),
+	}
+	if _, err := rand.Read(entry.Nonce); err != nil {
+		return "", Entry{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	entry.Ciphertext = gcm.Seal(nil, entry.Nonce, plaintext, []byte(entry.ID))
+	return token, entry, nil
+}
+
+// open decrypts the credentials of an entry with its token
+func (v *Vault) open(token string, entry Entry) (decrypt.Credentials, error) {
+	gcm, err := v.cipher(token)
+	if err != nil {
+		return decrypt.Credentials{}, err
+	}
+	plaintext, err := gcm.Open(nil, entry.Nonce, entry.Ciphertext, []byte(entry.ID))
+	if err != nil {
+		return decrypt.Credentials{}, fmt.Errorf("failed to decrypt subscription: %w", err)
+	}
+
+	var creds decrypt.Credentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return decrypt.Credentials{}, fmt.Errorf("failed to parse subscription: %w", err)
+	}
+	return creds, nil
+}
+
+// cipher returns the AEAD of a token, keyed by HMAC-SHA256(vault key, token)
+func (v *Vault) cipher(token string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write([]byte(token))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// load reads an entry, the caller must hold the lock
+func (v *Vault) load(id string) (Entry, error) {
+	data, err := os.ReadFile(v.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return Entry{}, ErrNotFound
+	}
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to read subscription: %w", err)
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return Entry{}, fmt.Errorf("failed to parse subscription: %w", err)
+	}
+	return entry, nil
+}
+
+// save atomically writes an entry, the caller must hold the lock
+func (v *Vault) save(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal subscription: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(v.dir, entry.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create subscription file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write subscription file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write subscription file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), v.path(entry.ID)); err != nil {
+		return fmt.Errorf("failed to replace subscription file: %w", err)
+	}
+	return nil
+}
+
+// remove deletes an entry, the caller must hold the lock
+func (v *Vault) remove(id string) error {
+	err := os.Remove(v.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete subscription: %w", err)
+	}
+	return nil
+}
+
+func (v *Vault) path(id string) string {
+	return filepath.Join(v.dir, id+".json")
+}
+
+// newToken returns a random URL-safe token
+func newToken() (string, error) {
+	token := make([]byte, tokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
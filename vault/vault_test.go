@@ -0,0 +1,154 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cpe/calendar/decrypt"
+)
+
+// newVault opens an empty vault in a temporary directory
+func newVault(t *testing.T) *Vault {
+	t.Helper()
+	v, err := Open(t.TempDir(), bytes.Repeat([]byte{7}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// put stores credentials of subscriber and returns the token
+func put(t *testing.T, v *Vault, subscriber, username string, now time.Time) string {
+	t.Helper()
+	token, err := v.Put(subscriber, decrypt.Credentials{Version: 1, Username: username, Password: "password"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestPutGet(t *testing.T) {
+	v := newVault(t)
+	token := put(t, v, "alice", "alice@cpe.fr", time.Now())
+
+	creds, err := v.Get(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Username != "alice@cpe.fr" || creds.Password != "password" {
+		t.Errorf("got %+v", creds)
+	}
+
+	// Neither the token nor the credentials are written to disk
+	data, err := os.ReadFile(v.path(TokenID(token)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{token, "alice@cpe.fr", "password"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("%q is stored in clear", secret)
+		}
+	}
+
+	for _, unknown := range []string{"", "short", strings.Repeat("A", 32), "../../../../etc/passwd"} {
+		if _, err := v.Get(unknown); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q): got %v, want %v", unknown, err, ErrNotFound)
+		}
+	}
+}
+
+func TestSubscribersAreIsolated(t *testing.T) {
+	v := newVault(t)
+	now := time.Now()
+	aliceToken := put(t, v, "alice", "alice@cpe.fr", now)
+	bobToken := put(t, v, "bob", "bob@cpe.fr", now.Add(time.Second))
+	aliceID := TokenID(aliceToken)
+
+	entries, err := v.List("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != TokenID(bobToken) {
+		t.Fatalf("bob lists %+v, want only his feed", entries)
+	}
+
+	// Bob knows the ID of Alice's feed but cannot act on it
+	if err := v.RevokeID("bob", aliceID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RevokeID: got %v, want %v", err, ErrNotFound)
+	}
+	if _, err := v.Replace("bob", aliceID, decrypt.Credentials{Username: "bob@cpe.fr"}, now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Replace: got %v, want %v", err, ErrNotFound)
+	}
+	if revoked, err := v.RevokeAll("bob"); err != nil || revoked != 1 {
+		t.Errorf("RevokeAll: got %d, %v, want 1", revoked, err)
+	}
+
+	creds, err := v.Get(aliceToken)
+	if err != nil || creds.Username != "alice@cpe.fr" {
+		t.Errorf("Alice's feed was changed by Bob: %+v, %v", creds, err)
+	}
+	if _, err := v.Get(bobToken); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Bob's feed survived RevokeAll: %v", err)
+	}
+}
+
+func TestUnknownIDs(t *testing.T) {
+	v := newVault(t)
+	put(t, v, "alice", "alice@cpe.fr", time.Now())
+
+	for _, id := range []string{strings.Repeat("0", 64), "not-an-id", "../alice"} {
+		if err := v.RevokeID("alice", id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RevokeID(%q): got %v, want %v", id, err, ErrNotFound)
+		}
+		if _, err := v.Replace("alice", id, decrypt.Credentials{}, time.Now()); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Replace(%q): got %v, want %v", id, err, ErrNotFound)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	v := newVault(t)
+	now := time.Now()
+	old := put(t, v, "alice", "alice@cpe.fr", now)
+
+	token, err := v.Replace("alice", TokenID(old), decrypt.Credentials{Version: 1, Username: "alice@cpe.fr", Password: "new password"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Get(old); !errors.Is(err, ErrNotFound) {
+		t.Errorf("old token: got %v, want %v", err, ErrNotFound)
+	}
+	creds, err := v.Get(token)
+	if err != nil || creds.Password != "new password" {
+		t.Errorf("new token: got %+v, %v", creds, err)
+	}
+
+	entries, err := v.List("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != TokenID(token) {
+		t.Errorf("alice lists %+v, want only the new feed", entries)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	v := newVault(t)
+	token := put(t, v, "alice", "alice@cpe.fr", time.Now())
+	accessed := time.Date(2025, 2, 17, 12, 0, 0, 0, time.UTC)
+
+	if err := v.Touch(token, accessed, strings.Repeat("x", 500)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := v.List("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entries[0].LastAccess.Equal(accessed) || len(entries[0].UserAgent) != maxUserAgentSize {
+		t.Errorf("got %s and a %d bytes user agent", entries[0].LastAccess, len(entries[0].UserAgent))
+	}
+}
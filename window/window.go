@@ -0,0 +1,124 @@
+package window
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DateLayout is the date format used by mycpe and by the from/to query parameters
+const DateLayout = "2006-01-02"
+
+const (
+	// ModeRolling covers a number of days before and after today
+	ModeRolling = "rolling"
+	// ModeAcademic covers the current academic year, starting at the cut-over date
+	ModeAcademic = "academic"
+)
+
+// ErrInvalidRange is returned when a requested range is malformed or exceeds the allowed span
+var ErrInvalidRange = errors.New("invalid date range")
+
+// Window is a range of days, both ends included
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// StartDate returns the first day of the window as YYYY-MM-DD
+func (w Window) StartDate() string {
+	return w.Start.Format(DateLayout)
+}
+
+// EndDate returns the last day of the window as YYYY-MM-DD
+func (w Window) EndDate() string {
+	return w.End.Format(DateLayout)
+}
+
+// Policy describes how the default window is computed and how far requests may override it
+type Policy struct {
+	Mode         string
+	DaysBack     int
+	DaysForward  int
+	CutoverMonth time.Month
+	CutoverDay   int
+	MaxDays      int
+	Location     *time.Location
+}
+
+// DefaultPolicy is 30 days back and 180 days forward, with overrides capped to 400 days
+func DefaultPolicy() Policy {
+	return Policy{
+		Mode:         ModeRolling,
+		DaysBack:     30,
+		DaysForward:  180,
+		CutoverMonth: time.September,
+		CutoverDay:   1,
+		MaxDays:      400,
+		Location:     time.UTC,
+	}
+}
+
+// Default returns the window served when the request does not ask for a specific range
+func (p Policy) Default(now time.Time) Window {
+	today := p.day(now)
+
+	if p.Mode == ModeAcademic {
+		start := time.Date(today.Year(), p.CutoverMonth, p.CutoverDay, 0, 0, 0, 0, p.location())
+		if today.Before(start) {
+			start = start.AddDate(-1, 0, 0)
+		}
+		return Window{Start: start, End: start.AddDate(1, 0, -1)}
+	}
+
+	return Window{
+		Start: today.AddDate(0, 0, -p.DaysBack),
+		End:   today.AddDate(0, 0, p.DaysForward),
+	}
+}
+
+// Resolve returns the default window, with its bounds replaced by from and to (YYYY-MM-DD) when given.
+// The resulting range must not be reversed, nor span more than MaxDays when the caller chose a bound:
+// the configured default is always served, whatever its length.
+func (p Policy) Resolve(now time.Time, from, to string) (Window, error) {
+	w := p.Default(now)
+
+	if from != "" {
+		start, err := time.ParseInLocation(DateLayout, from, p.location())
+		if err != nil {
+			return w, fmt.Errorf("%w: bad from date %q", ErrInvalidRange, from)
+		}
+		w.Start = start
+	}
+
+	if to != "" {
+		end, err := time.ParseInLocation(DateLayout, to, p.location())
+		if err != nil {
+			return w, fmt.Errorf("%w: bad to date %q", ErrInvalidRange, to)
+		}
+		w.End = end
+	}
+
+	if w.End.Before(w.Start) {
+		return w, fmt.Errorf("%w: %s is before %s", ErrInvalidRange, w.EndDate(), w.StartDate())
+	}
+
+	if (from != "" || to != "") && p.MaxDays > 0 && w.End.Sub(w.Start) > time.Duration(p.MaxDays)*24*time.Hour {
+		return w, fmt.Errorf("%w: more than %d days requested", ErrInvalidRange, p.MaxDays)
+	}
+
+	return w, nil
+}
+
+// day truncates t to midnight in the policy location
+func (p Policy) day(t time.Time) time.Time {
+	t = t.In(p.location())
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, p.location())
+}
+
+func (p Policy) location() *time.Location {
+	if p.Location == nil {
+		return time.UTC
+	}
+	return p.Location
+}
@@ -0,0 +1,166 @@
+package window
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResolveMaxDays(t *testing.T) {
+	now := time.Date(2025, 2, 17, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		from, to string
+		wantErr  bool
+	}{
+		{
+			name:   "rolling default longer than max days",
+			policy: Policy{Mode: ModeRolling, DaysBack: 200, DaysForward: 300, MaxDays: 400},
+		},
+		{
+			name:   "academic default longer than max days",
+			policy: Policy{Mode: ModeAcademic, CutoverMonth: time.September, CutoverDay: 1, MaxDays: 100},
+		},
+		{
+			name:   "requested range within max days",
+			policy: DefaultPolicy(),
+			from:   "2025-01-01",
+			to:     "2025-06-30",
+		},
+		{
+			name:    "requested range longer than max days",
+			policy:  DefaultPolicy(),
+			from:    "2024-01-01",
+			to:      "2025-06-30",
+			wantErr: true,
+		},
+		{
+			name:    "requested bound stretching the default past max days",
+			policy:  Policy{Mode: ModeRolling, DaysBack: 30, DaysForward: 30, MaxDays: 90},
+			from:    "2024-01-01",
+			wantErr: true,
+		},
+		{
+			name:    "reversed range",
+			policy:  DefaultPolicy(),
+			from:    "2025-03-01",
+			to:      "2025-02-01",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.policy.Resolve(now, tt.from, tt.to)
+			if tt.wantErr && !errors.Is(err, ErrInvalidRange) {
+				t.Errorf("got %v, want ErrInvalidRange", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("got %v, want no error", err)
+			}
+		})
+	}
+}
+
+func TestDefault(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	academic := Policy{Mode: ModeAcademic, CutoverMonth: time.September, CutoverDay: 1, Location: paris}
+	rolling := Policy{Mode: ModeRolling, DaysBack: 30, DaysForward: 180, Location: paris}
+
+	tests := []struct {
+		name      string
+		policy    Policy
+		now       time.Time
+		wantStart string
+		wantEnd   string
+	}{
+		{
+			name:      "academic just before the cut-over",
+			policy:    academic,
+			now:       time.Date(2025, 8, 31, 23, 59, 0, 0, paris),
+			wantStart: "2024-09-01", wantEnd: "2025-08-31",
+		},
+		{
+			// Still August 31 in UTC, already the new year in Paris
+			name:      "academic just after the cut-over",
+			policy:    academic,
+			now:       time.Date(2025, 8, 31, 22, 30, 0, 0, time.UTC),
+			wantStart: "2025-09-01", wantEnd: "2026-08-31",
+		},
+		{
+			name:      "academic in the spring",
+			policy:    academic,
+			now:       time.Date(2025, 2, 17, 10, 0, 0, 0, paris),
+			wantStart: "2024-09-01", wantEnd: "2025-08-31",
+		},
+		{
+			name:      "academic with a custom cut-over",
+			policy:    Policy{Mode: ModeAcademic, CutoverMonth: time.August, CutoverDay: 25, Location: paris},
+			now:       time.Date(2025, 8, 25, 0, 0, 0, 0, paris),
+			wantStart: "2025-08-25", wantEnd: "2026-08-24",
+		},
+		{
+			name:      "academic over a leap day",
+			policy:    academic,
+			now:       time.Date(2024, 2, 29, 12, 0, 0, 0, paris),
+			wantStart: "2023-09-01", wantEnd: "2024-08-31",
+		},
+		{
+			name:      "rolling",
+			policy:    rolling,
+			now:       time.Date(2025, 2, 17, 10, 0, 0, 0, paris),
+			wantStart: "2025-01-18", wantEnd: "2025-08-16",
+		},
+		{
+			// Today is the day in the policy location, not in UTC
+			name:      "rolling late in the evening",
+			policy:    Policy{Mode: ModeRolling, DaysBack: 1, DaysForward: 1, Location: paris},
+			now:       time.Date(2025, 3, 30, 22, 30, 0, 0, time.UTC),
+			wantStart: "2025-03-30", wantEnd: "2025-04-01",
+		},
+		{
+			name:      "rolling today only",
+			policy:    Policy{Mode: ModeRolling, Location: paris},
+			now:       time.Date(2025, 2, 17, 10, 0, 0, 0, paris),
+			wantStart: "2025-02-17", wantEnd: "2025-02-17",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.policy.Default(tt.now)
+			if w.StartDate() != tt.wantStart || w.EndDate() != tt.wantEnd {
+				t.Errorf("got %s to %s, want %s to %s", w.StartDate(), w.EndDate(), tt.wantStart, tt.wantEnd)
+			}
+			if w.Start.Location() != paris || w.Start.Hour() != 0 || w.End.Hour() != 0 {
+				t.Errorf("bounds %s and %s are not midnights in %s", w.Start, w.End, paris)
+			}
+		})
+	}
+}
+
+func TestResolveKeepsTheOtherDefaultBound(t *testing.T) {
+	now := time.Date(2025, 2, 17, 10, 0, 0, 0, time.UTC)
+	policy := DefaultPolicy()
+
+	w, err := policy.Resolve(now, "2025-02-01", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.StartDate() != "2025-02-01" || w.EndDate() != "2025-08-16" {
+		t.Errorf("got %s to %s, want the requested start and the default end", w.StartDate(), w.EndDate())
+	}
+
+	w, err = policy.Resolve(now, "", "2025-03-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.StartDate() != "2025-01-18" || w.EndDate() != "2025-03-01" {
+		t.Errorf("got %s to %s, want the default start and the requested end", w.StartDate(), w.EndDate())
+	}
+}